@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/sorcix/irc.v1"
@@ -10,11 +11,14 @@ import (
 	"github.com/magnesium38/lbdemo/common"
 )
 
-// NewApp creates a new app server worker.
-func NewApp(config *common.Config) (*AppServer, error) {
+// NewApp creates a new app server worker. The jobs are what it reports on
+//   in its status.
+func NewApp(config *common.Config, jobs *common.WorkGroup) (*AppServer, error) {
 	worker := AppServer{
 		config,
-		true,
+		atomic.Bool{},
+		make(chan struct{}, 1),
+		jobs,
 	}
 
 	return &worker, nil
@@ -22,20 +26,25 @@ func NewApp(config *common.Config) (*AppServer, error) {
 
 type AppServer struct {
 	config *common.Config
-	doWork bool
+	halted atomic.Bool
+	halt   chan struct{}
+	jobs   *common.WorkGroup
 }
 
 func (worker *AppServer) Work() error {
 	// This isn't real work. Parse to a db maybe?
 	// TO DO: literally anything else here.
-	for worker.doWork {
-		time.Sleep(time.Minute)
+	for !worker.halted.Load() {
+		select {
+		case <-worker.halt:
+		case <-time.After(time.Minute):
+		}
 	}
-	return nil
+	return common.ErrHalted
 }
 
 func (worker *AppServer) Shutdown() {
-
+	worker.Do("HALT")
 }
 
 func (worker *AppServer) process(msg *irc.Message) string {
@@ -55,6 +64,16 @@ func (worker *AppServer) process(msg *irc.Message) string {
 }
 
 func (worker *AppServer) Do(work string) (string, error) {
+	// A halt comes from the master rather than IRC, it stops the worker.
+	if work == "HALT" {
+		worker.halted.Store(true)
+		select {
+		case worker.halt <- struct{}{}:
+		default:
+		}
+		return "", nil
+	}
+
 	// Parse the message into a workable format.
 	message := irc.ParseMessage(work)
 
@@ -64,6 +83,7 @@ func (worker *AppServer) Do(work string) (string, error) {
 }
 
 func (worker *AppServer) Status(requestTime time.Time) balancer.Status {
-	// TO DO: revisit statuses.
-	return common.NewStatus()
+	status := common.NewStatus()
+	status.Jobs = worker.jobs.Stats()
+	return status
 }
@@ -0,0 +1,170 @@
+// Package api is the contract between the tiers. It names every RPC service
+//   and method one tier calls on another, the types that travel with them,
+//   and the stubs used to make the calls, so a mismatch is caught by the
+//   compiler instead of at runtime.
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// The services published over RPC. A load balancer publishes Master, a node
+//   publishes Server, and the rest are on a master's control server.
+const (
+	MasterService      = "Master"
+	NodeService        = common.NodeService
+	CoordinatorService = "Coordinator"
+	ChannelsService    = "Channels"
+	RegistryService    = "Registry"
+	LauncherService    = "Launcher"
+	SchedulerService   = "Scheduler"
+)
+
+// The methods called between tiers.
+const (
+	MasterWork         = MasterService + ".Work"
+	NodeDo             = common.NodeDo
+	NodeStatus         = NodeService + ".Status"
+	CoordinatorAcquire = CoordinatorService + ".Acquire"
+	CoordinatorReport  = CoordinatorService + ".Report"
+	CoordinatorRelease = CoordinatorService + ".Release"
+	CoordinatorLease   = CoordinatorService + ".Lease"
+	CoordinatorHolder  = CoordinatorService + ".Holder"
+	ChannelsMove       = ChannelsService + ".Move"
+	ChannelsList       = ChannelsService + ".List"
+	ChannelsJoin       = ChannelsService + ".Join"
+	ChannelsPart       = ChannelsService + ".Part"
+	RegistryRegister   = RegistryService + ".Register"
+	RegistryHeartbeat  = RegistryService + ".Heartbeat"
+	RegistryDeregister = RegistryService + ".Deregister"
+	RegistryNodes      = RegistryService + ".Nodes"
+	LauncherLaunch     = LauncherService + ".Launch"
+	LauncherStop       = LauncherService + ".Stop"
+	LauncherNodes      = LauncherService + ".Nodes"
+	SchedulerSchedule  = SchedulerService + ".Schedule"
+	SchedulerList      = SchedulerService + ".List"
+	SchedulerCancel    = SchedulerService + ".Cancel"
+)
+
+// AcquireArgs is what a writer node sends when it wants to send messages.
+//   The account is the one it leased, so a coordinator that lost track of
+//   the lease can take it back up.
+type AcquireArgs struct {
+	Node      string
+	Account   string
+	Count     int
+	Moderator bool
+}
+
+// AcquireReply tells a writer node whether it may send. A Wait of zero
+//   means the tokens were granted, otherwise the node should try again
+//   after waiting that long.
+type AcquireReply struct {
+	Wait  time.Duration
+	Share int
+}
+
+// ReportArgs is what a writer node sends to say how it is doing, along
+//   with the account it leased.
+type ReportArgs struct {
+	Node    string
+	Account string
+	Status  WriterStatus
+}
+
+// A WriterStatus is what a writer node reports to the writer master. The
+//   autoscaler sizes the tier by the messages sent and how long they
+//   waited, and the scheduler hands work to the node with the least
+//   queued.
+type WriterStatus struct {
+	// QueueDepth is how many payloads are waiting in each priority.
+	QueueDepth map[string]int `json:"queueDepth"`
+	// MessagesSent is how many chat messages went out in the last message
+	//   window, and QueueWait is how long they waited on average.
+	MessagesSent int           `json:"messagesSent"`
+	QueueWait    time.Duration `json:"queueWait"`
+	// Jobs is how the node's jobs have been doing.
+	Jobs []common.JobStats `json:"jobs"`
+}
+
+func (status *WriterStatus) GetIdleTime() time.Duration {
+	return time.Minute
+}
+
+func (status *WriterStatus) String() string {
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
+}
+
+func (status *WriterStatus) Update(z string) {
+	json.Unmarshal([]byte(z), status)
+}
+
+// MoveArgs names a reader and the channels to move off of it.
+type MoveArgs struct {
+	Node     string
+	Channels []string
+}
+
+// ScheduleArgs describes writer work to send later, either at a time or
+//   after a delay from now. Due is used if it is set.
+type ScheduleArgs struct {
+	Work  string
+	Due   time.Time
+	Delay time.Duration
+}
+
+// A ScheduledSend is a piece of writer work waiting to be sent. The node is
+//   the writer it was first handed to, if it was.
+type ScheduledSend struct {
+	ID   string    `json:"id"`
+	Work string    `json:"work"`
+	Due  time.Time `json:"due"`
+	Node string    `json:"node,omitempty"`
+}
+
+// A Coordinator is what the writer master publishes as CoordinatorService.
+type Coordinator interface {
+	Acquire(args AcquireArgs, reply *AcquireReply) error
+	Report(args ReportArgs, reply *bool) error
+	Release(node string, reply *bool) error
+	Lease(node string, account *string) error
+	Holder(account string, node *string) error
+}
+
+// Channels is what the reader master publishes as ChannelsService.
+type Channels interface {
+	Move(args MoveArgs, moved *int) error
+	List(unused bool, channels *map[string][]string) error
+	Join(channel string, node *string) error
+	Part(channel string, node *string) error
+}
+
+// A Scheduler is what the writer master publishes as SchedulerService.
+type Scheduler interface {
+	Schedule(args ScheduleArgs, reply *ScheduledSend) error
+	List(unused bool, reply *[]ScheduledSend) error
+	Cancel(id string, reply *bool) error
+}
+
+// RegisterCoordinator publishes a Coordinator on a control server.
+func RegisterCoordinator(control *common.ControlServer, coordinator Coordinator) error {
+	return control.Register(CoordinatorService, coordinator)
+}
+
+// RegisterChannels publishes Channels on a control server.
+func RegisterChannels(control *common.ControlServer, channels Channels) error {
+	return control.Register(ChannelsService, channels)
+}
+
+// RegisterScheduler publishes a Scheduler on a control server.
+func RegisterScheduler(control *common.ControlServer, scheduler Scheduler) error {
+	return control.Register(SchedulerService, scheduler)
+}
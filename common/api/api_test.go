@@ -0,0 +1,474 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+type fakeMaster struct {
+}
+
+func (master *fakeMaster) Work(work string, reply *string) error {
+	*reply = "worked " + work
+	return nil
+}
+
+type fakeCoordinator struct {
+	acquired AcquireArgs
+	reported ReportArgs
+	released string
+}
+
+func (coordinator *fakeCoordinator) Acquire(args AcquireArgs, reply *AcquireReply) error {
+	coordinator.acquired = args
+	*reply = AcquireReply{Wait: time.Second, Share: 3}
+	return nil
+}
+
+func (coordinator *fakeCoordinator) Report(args ReportArgs, reply *bool) error {
+	coordinator.reported = args
+	*reply = true
+	return nil
+}
+
+func (coordinator *fakeCoordinator) Release(node string, reply *bool) error {
+	coordinator.released = node
+	*reply = true
+	return nil
+}
+
+func (coordinator *fakeCoordinator) Lease(node string, account *string) error {
+	*account = "bot"
+	return nil
+}
+
+func (coordinator *fakeCoordinator) Holder(account string, node *string) error {
+	if account != "bot" {
+		return errors.New("No writer node has leased the account: " + account)
+	}
+
+	*node = "localhost:9000"
+	return nil
+}
+
+type fakeChannels struct {
+	moved MoveArgs
+}
+
+func (channels *fakeChannels) Move(args MoveArgs, moved *int) error {
+	channels.moved = args
+	*moved = len(args.Channels)
+	return nil
+}
+
+func (channels *fakeChannels) List(unused bool, listening *map[string][]string) error {
+	*listening = map[string][]string{"localhost:9000": {"#a"}}
+	return nil
+}
+
+func (channels *fakeChannels) Join(channel string, node *string) error {
+	*node = "localhost:9000"
+	return nil
+}
+
+func (channels *fakeChannels) Part(channel string, node *string) error {
+	return errors.New("No reader is listening on the channel: " + channel)
+}
+
+type fakeScheduler struct {
+	scheduled ScheduleArgs
+}
+
+func (scheduler *fakeScheduler) Schedule(args ScheduleArgs, reply *ScheduledSend) error {
+	scheduler.scheduled = args
+	*reply = ScheduledSend{ID: "a", Work: args.Work, Due: args.Due}
+	return nil
+}
+
+func (scheduler *fakeScheduler) List(unused bool, reply *[]ScheduledSend) error {
+	*reply = []ScheduledSend{{ID: "a", Work: "SAY #a hello", Node: "localhost:9000"}}
+	return nil
+}
+
+func (scheduler *fakeScheduler) Cancel(id string, reply *bool) error {
+	return errors.New("No scheduled send with the id: " + id)
+}
+
+type echoWorker struct {
+}
+
+func (worker *echoWorker) Do(work string) (string, error) {
+	if work == "" {
+		return "", errors.New("Nothing to do.")
+	}
+
+	return work, nil
+}
+
+func (worker *echoWorker) Status(requestTime time.Time) balancer.Status {
+	return &WriterStatus{MessagesSent: 7}
+}
+
+// serveControl starts a control server with the given services published
+//   on it, and returns its address once it is answering.
+func serveControl(t *testing.T, register func(control *common.ControlServer) error) string {
+	port, err := common.GetOpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connInfo := common.ConnInfo{Hostname: "localhost", Port: port}
+	control := common.NewControlServer(connInfo, nil, nil)
+	if err := register(control); err != nil {
+		t.Fatal(err)
+	}
+	go control.ListenAndServe()
+	t.Cleanup(func() { control.Close() })
+
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", connInfo.String()); err == nil {
+			conn.Close()
+			return connInfo.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("The control server never started.")
+	return ""
+}
+
+func TestMasterClient(t *testing.T) {
+	// The load balancer publishes itself under its own type's name.
+	server := rpc.NewServer()
+	if err := server.RegisterName(MasterService, &fakeMaster{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, server)
+
+	master := NewMasterClient(listener.Addr().String(), common.ClientConfig{})
+	defer master.Close()
+
+	reply, err := master.Work("PRIVMSG #channel :hi")
+	if err != nil || reply != "worked PRIVMSG #channel :hi" {
+		t.Error(reply, err, "The work did not reach the master.")
+	}
+}
+
+func TestNodeClient(t *testing.T) {
+	listener, _, err := common.Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	node, err := common.NewNode(listener, &echoWorker{}, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	client := NewNodeClient(listener.Addr().String(), common.ClientConfig{})
+	defer client.Close()
+
+	reply, err := client.Do("JOIN #channel")
+	if err != nil || reply != "JOIN #channel" {
+		t.Error(reply, err, "The work did not reach the node.")
+	}
+
+	if _, err := client.Do(""); err == nil {
+		t.Error("The worker's error was not returned.")
+	}
+
+	response, err := client.Status(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status WriterStatus
+	status.Update(response)
+	if status.MessagesSent != 7 {
+		t.Error(response, "The status did not survive the trip.")
+	}
+
+	// Reading the status into a copy leaves the connection's own alone.
+	conn, err := NewConnection(listener.Addr().String(), &WriterStatus{}, common.ClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var copied WriterStatus
+	if err := conn.ReadStatus(&copied); err != nil || copied.MessagesSent != 7 {
+		t.Error(copied, err, "The status was not read into the copy.")
+	}
+
+	if own := conn.GetStatus().(*WriterStatus); own.MessagesSent != 0 {
+		t.Error(own, "The connection's own status was changed.")
+	}
+}
+
+func TestCoordinatorClient(t *testing.T) {
+	coordinator := &fakeCoordinator{}
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return RegisterCoordinator(control, coordinator)
+	})
+
+	client := NewCoordinatorClient(addr, common.ClientConfig{})
+	defer client.Close()
+
+	args := AcquireArgs{Node: "localhost:9000", Account: "bot", Count: 2, Moderator: true}
+	reply, err := client.Acquire(args)
+	if err != nil || reply.Wait != time.Second || reply.Share != 3 {
+		t.Error(reply, err, "The reply did not survive the trip.")
+	}
+	if coordinator.acquired != args {
+		t.Error(coordinator.acquired, "The arguments did not survive the trip.")
+	}
+
+	status := WriterStatus{
+		QueueDepth:   map[string]int{"reply": 1},
+		MessagesSent: 4,
+		QueueWait:    time.Millisecond,
+		Jobs:         []common.JobStats{{Name: "work", Restarts: 1}},
+	}
+	if err := client.Report("localhost:9000", "bot", status); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(coordinator.reported, ReportArgs{"localhost:9000", "bot", status}) {
+		t.Error(coordinator.reported, "The report did not survive the trip.")
+	}
+
+	if err := client.Release("localhost:9000"); err != nil || coordinator.released != "localhost:9000" {
+		t.Error(coordinator.released, err, "The release did not reach the coordinator.")
+	}
+
+	if account, err := client.Lease("localhost:9000"); err != nil || account != "bot" {
+		t.Error(account, err, "The lease did not survive the trip.")
+	}
+
+	if node, err := client.Holder("bot"); err != nil || node != "localhost:9000" {
+		t.Error(node, err, "The holder did not survive the trip.")
+	}
+
+	if _, err := client.Holder("brand"); err == nil {
+		t.Error("An account nobody leased should have no holder.")
+	}
+}
+
+func TestChannelsClient(t *testing.T) {
+	channels := &fakeChannels{}
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return RegisterChannels(control, channels)
+	})
+
+	client := NewChannelsClient(addr, common.ClientConfig{})
+	defer client.Close()
+
+	args := MoveArgs{Node: "localhost:9000", Channels: []string{"#a", "#b"}}
+	moved, err := client.Move(args, time.Now().Add(time.Second))
+	if err != nil || moved != 2 {
+		t.Error(moved, err, "The move did not reach the master.")
+	}
+	if !reflect.DeepEqual(channels.moved, args) {
+		t.Error(channels.moved, "The arguments did not survive the trip.")
+	}
+
+	listening, err := client.List()
+	if err != nil || len(listening["localhost:9000"]) != 1 {
+		t.Error(listening, err, "The list did not survive the trip.")
+	}
+
+	if node, err := client.Join("#c"); err != nil || node != "localhost:9000" {
+		t.Error(node, err, "The join did not reach the master.")
+	}
+
+	if _, err := client.Part("#c"); err == nil {
+		t.Error("The master's error was not returned.")
+	}
+}
+
+func TestSchedulerClient(t *testing.T) {
+	scheduler := &fakeScheduler{}
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return RegisterScheduler(control, scheduler)
+	})
+
+	client := NewSchedulerClient(addr, common.ClientConfig{})
+	defer client.Close()
+
+	args := ScheduleArgs{Work: "SAY #a hello", Due: time.Now().Round(0).Add(time.Hour)}
+	send, err := client.Schedule(args)
+	if err != nil || send.ID != "a" || !send.Due.Equal(args.Due) {
+		t.Error(send, err, "The send did not survive the trip.")
+	}
+	if scheduler.scheduled.Work != args.Work || !scheduler.scheduled.Due.Equal(args.Due) {
+		t.Error(scheduler.scheduled, "The arguments did not survive the trip.")
+	}
+
+	sends, err := client.List()
+	if err != nil || len(sends) != 1 || sends[0].Node != "localhost:9000" {
+		t.Error(sends, err, "The list did not survive the trip.")
+	}
+
+	if err := client.Cancel("b"); err == nil {
+		t.Error("The master's error was not returned.")
+	}
+}
+
+func TestRegistryClient(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbdemo-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := common.NewFileRegistry(filepath.Join(dir, "nodes.txt"), time.Minute)
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return control.Register(RegistryService, common.NewRegistryService(backend))
+	})
+
+	var registry common.Registry = NewRegistryClient(addr, common.ClientConfig{})
+	if err := registry.Register("localhost:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Heartbeat("localhost:9001"); err == nil {
+		t.Error("An unknown node should not be able to heartbeat.")
+	}
+
+	nodes, err := backend.Nodes()
+	if err != nil || len(nodes) != 1 || nodes[0] != "localhost:9000" {
+		t.Error(nodes, err, "The node should be registered with the master.")
+	}
+
+	if err := registry.Deregister("localhost:9000"); err != nil {
+		t.Error(err)
+	}
+
+	nodes, err = registry.Nodes()
+	if err != nil || len(nodes) != 0 {
+		t.Error(nodes, err, "The node should be deregistered.")
+	}
+}
+
+// fakeLauncher stands in for the nodes a master starts.
+type fakeLauncher struct {
+	nodes []string
+}
+
+func (launcher *fakeLauncher) Launch() (string, error) {
+	launcher.nodes = append(launcher.nodes, "localhost:9000")
+	return "localhost:9000", nil
+}
+
+func (launcher *fakeLauncher) Stop(node string) error {
+	return errors.New("Not a node this launcher manages: " + node)
+}
+
+func (launcher *fakeLauncher) Nodes() []string {
+	return launcher.nodes
+}
+
+func TestLauncherClient(t *testing.T) {
+	launcher := &fakeLauncher{}
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return control.Register(LauncherService, common.NewLauncherService(launcher))
+	})
+
+	client := NewLauncherClient(addr, common.ClientConfig{})
+	defer client.Close()
+
+	if node, err := client.Launch(); err != nil || node != "localhost:9000" {
+		t.Error(node, err, "The launch did not reach the master.")
+	}
+
+	if nodes, err := client.Nodes(); err != nil || len(nodes) != 1 {
+		t.Error(nodes, err, "The nodes did not survive the trip.")
+	}
+
+	if err := client.Stop("localhost:9001"); err == nil {
+		t.Error("The master's error was not returned.")
+	}
+}
+
+func TestMasterProxy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbdemo-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Stand in for the load balancer.
+	server := rpc.NewServer()
+	if err := server.RegisterName(MasterService, &fakeMaster{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, server)
+
+	nodeListener, _, err := common.Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeListener.Close()
+
+	node, err := common.NewNode(nodeListener, &echoWorker{}, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	registry := common.NewFileRegistry(filepath.Join(dir, "nodes.txt"), time.Minute)
+	if err := registry.Register(nodeListener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewMasterProxy(listener.Addr().String(), registry, common.ClientConfig{}, 0)
+	addr := serveControl(t, func(control *common.ControlServer) error {
+		return RegisterMasterProxy(control, proxy)
+	})
+
+	// The proxy answers to the same calls as the load balancer.
+	master := NewMasterClient(addr, common.ClientConfig{})
+	defer master.Close()
+
+	if reply, err := master.Work("JOIN #a"); err != nil || reply != "worked JOIN #a" {
+		t.Error(reply, err, "The work did not reach the load balancer.")
+	}
+
+	var reply string
+	if err := proxy.Do(DoArgs{nodeListener.Addr().String(), "JOIN #b"}, &reply); err != nil || reply != "JOIN #b" {
+		t.Error(reply, err, "The work did not reach the node.")
+	}
+
+	if err := proxy.Do(DoArgs{"localhost:1", "HALT"}, &reply); err == nil {
+		t.Error("Work should only be sent to the tier's own nodes.")
+	}
+
+	var status json.RawMessage
+	if err := proxy.Status(nodeListener.Addr().String(), &status); err != nil || !strings.Contains(string(status), `"messagesSent":7`) {
+		t.Error(string(status), err, "The node's status was not returned.")
+	}
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// stub is what every client shares. The connection is dialed again
+//   whenever the target restarts.
+type stub struct {
+	client *common.Client
+}
+
+// Connect dials the target now, rather than on the first call.
+func (target stub) Connect() error {
+	return target.client.Connect()
+}
+
+// Close closes the connection. The next call dials again.
+func (target stub) Close() error {
+	return target.client.Close()
+}
+
+// String returns the address of the target.
+func (target stub) String() string {
+	return target.client.String()
+}
+
+// NewMasterClient returns a client for the load balancer at addr.
+func NewMasterClient(addr string, config common.ClientConfig) *MasterClient {
+	return &MasterClient{stub{common.NewClient(addr, config)}}
+}
+
+// A MasterClient hands work to a tier through its load balancer.
+type MasterClient struct {
+	stub
+}
+
+// Work hands work to whichever node the load balancer picks.
+func (master *MasterClient) Work(work string) (string, error) {
+	var reply string
+	err := master.client.Call(MasterWork, work, &reply)
+	return reply, err
+}
+
+// WorkTimeout hands work to the load balancer and waits up to timeout for
+//   it to be done, or as long as it takes when the timeout is zero.
+func (master *MasterClient) WorkTimeout(work string, timeout time.Duration) (string, error) {
+	var reply string
+	err := master.client.CallTimeout(MasterWork, work, &reply, timeout)
+	return reply, err
+}
+
+// NewNodeClient returns a client for the node at addr.
+func NewNodeClient(addr string, config common.ClientConfig) *NodeClient {
+	return &NodeClient{stub{common.NewClient(addr, config)}}
+}
+
+// A NodeClient is how a load balancer talks to one of its nodes.
+type NodeClient struct {
+	stub
+}
+
+// Do hands work to the node.
+func (node *NodeClient) Do(work string) (string, error) {
+	var reply string
+	err := node.client.Call(NodeDo, work, &reply)
+	return reply, invalidWork(err)
+}
+
+// DoTimeout hands work to the node and waits up to timeout for it to be
+//   done, or as long as it takes when the timeout is zero.
+func (node *NodeClient) DoTimeout(work string, timeout time.Duration) (string, error) {
+	var reply string
+	err := node.client.CallTimeout(NodeDo, work, &reply, timeout)
+	return reply, invalidWork(err)
+}
+
+// invalidWork gives back the InvalidWorkError a node turned work down with,
+//   so the load balancer and other callers can tell it from a failure.
+func invalidWork(err error) error {
+	if invalid, ok := common.AsInvalidWork(err); ok {
+		return invalid
+	}
+
+	return err
+}
+
+// Status asks the node for its status in its string form.
+func (node *NodeClient) Status(requestTime time.Time) (string, error) {
+	var reply string
+	err := node.client.Call(NodeStatus, requestTime, &reply)
+	return reply, err
+}
+
+// NewCoordinatorClient returns a client for the coordinator on the writer
+//   master's control server at addr.
+func NewCoordinatorClient(addr string, config common.ClientConfig) *CoordinatorClient {
+	return &CoordinatorClient{stub{common.NewClient(addr, config)}}
+}
+
+// A CoordinatorClient is how a writer node shares the rate limit.
+type CoordinatorClient struct {
+	stub
+}
+
+// Acquire asks for send tokens.
+func (coordinator *CoordinatorClient) Acquire(args AcquireArgs) (AcquireReply, error) {
+	var reply AcquireReply
+	err := coordinator.client.Call(CoordinatorAcquire, args, &reply)
+	return reply, err
+}
+
+// Report tells the coordinator how a node logged in as account is doing.
+func (coordinator *CoordinatorClient) Report(node string, account string, status WriterStatus) error {
+	var reply bool
+	return coordinator.client.Call(CoordinatorReport, ReportArgs{node, account, status}, &reply)
+}
+
+// Release gives a node's share of the rate limit back.
+func (coordinator *CoordinatorClient) Release(node string) error {
+	var reply bool
+	return coordinator.client.Call(CoordinatorRelease, node, &reply)
+}
+
+// Lease asks for an account for a node to log in as. An empty account
+//   means the IRC config's own.
+func (coordinator *CoordinatorClient) Lease(node string) (string, error) {
+	var account string
+	err := coordinator.client.Call(CoordinatorLease, node, &account)
+	return account, err
+}
+
+// Holder returns the node that leased an account.
+func (coordinator *CoordinatorClient) Holder(account string) (string, error) {
+	var node string
+	err := coordinator.client.Call(CoordinatorHolder, account, &node)
+	return node, err
+}
+
+// NewRegistry returns the Registry a node should use according to the
+//   config. Either the registry file is shared, or the master is asked.
+func NewRegistry(config *common.Config) (common.Registry, error) {
+	switch config.Master.Registry {
+	case "", "file":
+		if config.Master.NodeRegistryPath == "" {
+			return nil, errors.New("The node registry path must not be empty.")
+		}
+
+		return common.NewFileRegistry(
+			config.Master.NodeRegistryPath,
+			time.Duration(config.Master.RegistryTTL)*time.Second), nil
+	case "rpc":
+		return NewRegistryClient(config.Master.Control.String(), config.Client), nil
+	default:
+		return nil, errors.New("Unknown registry: " + config.Master.Registry)
+	}
+}
+
+// NewRegistryClient returns a Registry that asks the master at addr, for
+//   nodes that don't share a filesystem with it.
+func NewRegistryClient(addr string, config common.ClientConfig) *RegistryClient {
+	return &RegistryClient{stub{common.NewClient(addr, config)}}
+}
+
+// A RegistryClient is how a node registers with a master's control server.
+type RegistryClient struct {
+	stub
+}
+
+// Register adds a node on the master.
+func (registry *RegistryClient) Register(node string) error {
+	var reply bool
+	return registry.client.Call(RegistryRegister, node, &reply)
+}
+
+// Heartbeat renews a node on the master.
+func (registry *RegistryClient) Heartbeat(node string) error {
+	var reply bool
+	return registry.client.Call(RegistryHeartbeat, node, &reply)
+}
+
+// Deregister removes a node from the master.
+func (registry *RegistryClient) Deregister(node string) error {
+	var reply bool
+	return registry.client.Call(RegistryDeregister, node, &reply)
+}
+
+// Nodes asks the master for every node it knows about.
+func (registry *RegistryClient) Nodes() ([]string, error) {
+	var nodes []string
+	err := registry.client.Call(RegistryNodes, true, &nodes)
+	return nodes, err
+}
+
+// NewLauncherClient returns a client for the launcher on a master's control
+//   server at addr.
+func NewLauncherClient(addr string, config common.ClientConfig) *LauncherClient {
+	return &LauncherClient{stub{common.NewClient(addr, config)}}
+}
+
+// A LauncherClient is how an operator starts and stops a tier's nodes.
+type LauncherClient struct {
+	stub
+}
+
+// Launch starts a node and returns its address.
+func (launcher *LauncherClient) Launch() (string, error) {
+	var node string
+	err := launcher.client.Call(LauncherLaunch, true, &node)
+	return node, err
+}
+
+// Stop drains a node and waits for it to exit.
+func (launcher *LauncherClient) Stop(node string) error {
+	var reply bool
+	return launcher.client.Call(LauncherStop, node, &reply)
+}
+
+// Nodes returns every node the launcher manages.
+func (launcher *LauncherClient) Nodes() ([]string, error) {
+	var nodes []string
+	err := launcher.client.Call(LauncherNodes, true, &nodes)
+	return nodes, err
+}
+
+// NewSchedulerClient returns a client for the scheduler on the writer
+//   master's control server at addr.
+func NewSchedulerClient(addr string, config common.ClientConfig) *SchedulerClient {
+	return &SchedulerClient{stub{common.NewClient(addr, config)}}
+}
+
+// A SchedulerClient is how work is handed to the writer tier to send later.
+type SchedulerClient struct {
+	stub
+}
+
+// Schedule holds work until it is due, and returns the send it became.
+func (scheduler *SchedulerClient) Schedule(args ScheduleArgs) (ScheduledSend, error) {
+	var send ScheduledSend
+	err := scheduler.client.Call(SchedulerSchedule, args, &send)
+	return send, err
+}
+
+// List returns every send that is waiting, soonest first.
+func (scheduler *SchedulerClient) List() ([]ScheduledSend, error) {
+	var sends []ScheduledSend
+	err := scheduler.client.Call(SchedulerList, true, &sends)
+	return sends, err
+}
+
+// Cancel removes a send before it goes out.
+func (scheduler *SchedulerClient) Cancel(id string) error {
+	var reply bool
+	return scheduler.client.Call(SchedulerCancel, id, &reply)
+}
+
+// NewChannelsClient returns a client for the channel service on the reader
+//   master's control server at addr.
+func NewChannelsClient(addr string, config common.ClientConfig) *ChannelsClient {
+	return &ChannelsClient{stub{common.NewClient(addr, config)}}
+}
+
+// A ChannelsClient is how a reader hands its channels to the rest of the
+//   tier.
+type ChannelsClient struct {
+	stub
+}
+
+// Move asks for the channels to be joined elsewhere, giving up at the
+//   deadline. It replies with how many were moved.
+func (channels *ChannelsClient) Move(args MoveArgs, deadline time.Time) (int, error) {
+	var moved int
+	err := channels.client.CallTimeout(ChannelsMove, args, &moved, time.Until(deadline))
+	return moved, err
+}
+
+// List returns the channels each reader is listening to.
+func (channels *ChannelsClient) List() (map[string][]string, error) {
+	var listening map[string][]string
+	err := channels.client.Call(ChannelsList, true, &listening)
+	return listening, err
+}
+
+// Join joins a channel on the reader with the most room, and returns which
+//   reader that was.
+func (channels *ChannelsClient) Join(channel string) (string, error) {
+	var node string
+	err := channels.client.Call(ChannelsJoin, channel, &node)
+	return node, err
+}
+
+// Part parts a channel on whichever reader is listening to it, and returns
+//   which reader that was.
+func (channels *ChannelsClient) Part(channel string) (string, error) {
+	var node string
+	err := channels.client.Call(ChannelsPart, channel, &node)
+	return node, err
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// NewConnectionFactory returns the NodeFactory a load balancer uses to reach
+//   its nodes. The connections it creates make their calls as the client
+//   config says, which includes signing them, and wait workTimeout for work
+//   to be done, see NewConnection.
+func NewConnectionFactory(status balancer.StatusFactory, config common.ClientConfig, workTimeout time.Duration) *ConnectionFactory {
+	return &ConnectionFactory{status, config, workTimeout}
+}
+
+// A ConnectionFactory creates a Connection for every node a load balancer
+//   finds.
+type ConnectionFactory struct {
+	status      balancer.StatusFactory
+	config      common.ClientConfig
+	workTimeout time.Duration
+}
+
+// Create takes the connection info and creates the connection struct.
+func (factory *ConnectionFactory) Create(connInfo string) (balancer.NodeConnection, error) {
+	return NewConnection(connInfo, factory.status.Create(), factory.config, factory.workTimeout)
+}
+
+// NewConnection returns a Connection to the node at connInfo, keeping its
+//   status in status. Work sent to the node waits workTimeout to be done,
+//   or as long as it takes when that is zero, for nodes whose work can
+//   rightly take a while.
+func NewConnection(connInfo string, status balancer.Status, config common.ClientConfig, workTimeout time.Duration) (*Connection, error) {
+	parts := strings.Split(connInfo, ":")
+	if len(parts) != 2 {
+		return nil, &balancer.InvalidWorkError{Str: "Node address is not host:port: " + connInfo}
+	}
+
+	port, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	conn := Connection{}
+	conn.host = parts[0]
+	conn.port = port
+	conn.jobCount = 0
+	conn.status = status
+	conn.client = NewNodeClient(connInfo, config)
+	conn.workTimeout = workTimeout
+
+	return &conn, nil
+}
+
+// A Connection is how a load balancer talks to one of its nodes.
+type Connection struct {
+	host        string
+	port        int
+	jobCount    int
+	status      balancer.Status
+	client      *NodeClient
+	workTimeout time.Duration
+}
+
+// GetHost returns the hostname that the node is listening on.
+func (conn *Connection) GetHost() string {
+	return conn.host
+}
+
+// GetPort returns the port that the node is listening on.
+func (conn *Connection) GetPort() int {
+	return conn.port
+}
+
+// AddJob increments the internal counter of jobs by 1.
+func (conn *Connection) AddJob() {
+	conn.jobCount++
+}
+
+// FinishJob decrements the internal counter of jobs by 1.
+func (conn *Connection) FinishJob() {
+	conn.jobCount--
+}
+
+// GetWorkLoad returns the current estimated work load that
+//   the node has. It is made possible through Add/FinishJob
+func (conn *Connection) GetWorkLoad() int {
+	return conn.jobCount
+}
+
+// String returns the address of the node.
+func (conn *Connection) String() string {
+	return conn.host + ":" + strconv.Itoa(conn.port)
+}
+
+// Connect initiates the connection between the balancer
+//   and the node. If the connection breaks later, it is dialed
+//   again on the next call.
+func (conn *Connection) Connect() error {
+	return conn.client.Connect()
+}
+
+// GetStatus returns the current Status of the node, or an error
+//   if it cannot retrieve the status.
+func (conn *Connection) GetStatus() balancer.Status {
+	return conn.status
+}
+
+// UpdateStatus requests the status from the node and stores it.
+func (conn *Connection) UpdateStatus() error {
+	// Request the status from the node.
+	requestTime := time.Now()
+	response, err := conn.client.Status(requestTime)
+
+	if err != nil {
+		return err
+	}
+
+	conn.status.Update(response)
+
+	return nil
+}
+
+// ReadStatus requests the status from the node into status, leaving the
+//   one the load balancer uses alone. Callers other than the load balancer
+//   use this so they don't race with it.
+func (conn *Connection) ReadStatus(status balancer.Status) error {
+	response, err := conn.client.Status(time.Now())
+	if err != nil {
+		return err
+	}
+
+	status.Update(response)
+
+	return nil
+}
+
+// Send is how a balancer can send work to the nodes. This
+//   implementation is using RPC.
+func (conn *Connection) Send(work string) (string, error) {
+	response, err := conn.client.DoTimeout(work, conn.workTimeout)
+
+	if err != nil {
+		fmt.Println("Send error: ", err)
+		return "", err
+	}
+
+	return response, nil
+}
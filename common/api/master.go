@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// DoArgs names a node and the work to hand it directly.
+type DoArgs struct {
+	Node string
+	Work string
+}
+
+// NewMasterProxy returns the service a master publishes on its control
+//   server for clients that can't call its load balancer themselves, like
+//   JSON-RPC ones. Work goes through the load balancer at addr like any
+//   other, and nodes are the ones in the registry. Work waits up to
+//   workTimeout, the same as the tier's connections, or as long as it
+//   takes when that is zero.
+func NewMasterProxy(addr string, registry common.Registry, config common.ClientConfig, workTimeout time.Duration) *MasterProxy {
+	return &MasterProxy{NewMasterClient(addr, config), registry, config, workTimeout}
+}
+
+// A MasterProxy publishes a tier's load balancer and its nodes under the
+//   load balancer's own service name.
+type MasterProxy struct {
+	master      *MasterClient
+	registry    common.Registry
+	config      common.ClientConfig
+	workTimeout time.Duration
+}
+
+// RegisterMasterProxy publishes a MasterProxy on a control server.
+func RegisterMasterProxy(control *common.ControlServer, proxy *MasterProxy) error {
+	return control.Register(MasterService, proxy)
+}
+
+// Work hands work to the load balancer.
+func (proxy *MasterProxy) Work(work string, reply *string) error {
+	response, err := proxy.master.WorkTimeout(work, proxy.workTimeout)
+	*reply = response
+	return err
+}
+
+// Do hands work straight to one of the tier's nodes, like DRAIN.
+func (proxy *MasterProxy) Do(args DoArgs, reply *string) error {
+	node, err := proxy.node(args.Node)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	response, err := node.DoTimeout(args.Work, proxy.workTimeout)
+	*reply = response
+	return err
+}
+
+// Nodes replies with every node in the tier.
+func (proxy *MasterProxy) Nodes(unused bool, nodes *[]string) error {
+	registered, err := proxy.registry.Nodes()
+	*nodes = registered
+	return err
+}
+
+// Status replies with the status one of the tier's nodes reports.
+func (proxy *MasterProxy) Status(name string, status *json.RawMessage) error {
+	node, err := proxy.node(name)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	response, err := node.Status(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !json.Valid([]byte(response)) {
+		return errors.New("The node's status is not JSON: " + name)
+	}
+
+	*status = json.RawMessage(response)
+	return nil
+}
+
+// node returns a client for a node, as long as it is one of the tier's.
+func (proxy *MasterProxy) node(name string) (*NodeClient, error) {
+	nodes, err := proxy.registry.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		if node == name {
+			return NewNodeClient(name, proxy.config), nil
+		}
+	}
+
+	return nil, errors.New("Not a node of this tier: " + name)
+}
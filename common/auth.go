@@ -0,0 +1,214 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnauthenticated is returned for a call that didn't carry a valid token,
+//   and to a caller whose token was turned down.
+var ErrUnauthenticated = errors.New("The call was not authenticated, check that every process has the cluster's secret.")
+
+// AuthHeader is the header of the RPC handshake that carries the token.
+const AuthHeader = "Authorization"
+
+// The scheme a token is sent under.
+const authScheme = "HMAC "
+
+// How far a token's time may be from the clock of the process checking it.
+const authSkew = 5 * time.Minute
+
+// How often the secret file is checked for changes.
+const authReload = time.Second
+
+// NewAuth returns the Auth the config asks for, or nil when there is no
+//   secret and calls are not authenticated.
+func NewAuth(config AuthConfig) (*Auth, error) {
+	if config.Secret == "" && config.SecretPath == "" {
+		return nil, nil
+	}
+
+	auth := &Auth{path: config.SecretPath}
+	if config.Secret != "" {
+		auth.secrets = [][]byte{[]byte(config.Secret)}
+	}
+
+	if auth.path != "" {
+		if err := auth.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return auth, nil
+}
+
+// An Auth signs and checks the tokens calls between tiers carry. A token is
+//   a time signed with the secret every process shares, so it can't be
+//   forged or reused for long.
+//
+// The secret file holds one secret per line. The first one signs, and every
+//   one is accepted. The file is read again whenever it changes, so the
+//   secret can be rotated without a restart: add the new secret as a second
+//   line everywhere, move it to the top, and then drop the old one.
+type Auth struct {
+	sync.Mutex
+	path    string
+	secrets [][]byte
+	modTime time.Time
+	checked time.Time
+}
+
+// Token returns a token for a call made now. Without an Auth, there is none.
+func (auth *Auth) Token() string {
+	if auth == nil {
+		return ""
+	}
+
+	secrets := auth.current()
+	stamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return stamp + "." + sign(secrets[0], stamp)
+}
+
+// Check returns ErrUnauthenticated unless the token was signed recently with
+//   one of the accepted secrets. Without an Auth, everything passes.
+func (auth *Auth) Check(token string) error {
+	if auth == nil {
+		return nil
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrUnauthenticated
+	}
+
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrUnauthenticated
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > authSkew || age < -authSkew {
+		return ErrUnauthenticated
+	}
+
+	for _, secret := range auth.current() {
+		if hmac.Equal([]byte(sign(secret, parts[0])), []byte(parts[1])) {
+			return nil
+		}
+	}
+
+	return ErrUnauthenticated
+}
+
+// Header returns the header value that carries a token for a call made now.
+func (auth *Auth) Header() string {
+	return authScheme + auth.Token()
+}
+
+// CheckHeader checks the token carried by a header value.
+func (auth *Auth) CheckHeader(header string) error {
+	if auth == nil {
+		return nil
+	}
+
+	if !strings.HasPrefix(header, authScheme) {
+		return ErrUnauthenticated
+	}
+
+	return auth.Check(strings.TrimPrefix(header, authScheme))
+}
+
+// Handler turns away requests without a valid token before they reach
+//   handler. Without an Auth, handler is returned as is.
+func (auth *Auth) Handler(handler http.Handler) http.Handler {
+	if auth == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.CheckHeader(r.Header.Get(AuthHeader)); err != nil {
+			fmt.Println("Rejected an unauthenticated call:", r.RemoteAddr)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// current returns the accepted secrets, reading the file again if it has
+//   changed. A file that can't be read keeps the secrets from before.
+func (auth *Auth) current() [][]byte {
+	if auth.path != "" {
+		auth.Lock()
+		due := time.Since(auth.checked) >= authReload
+		auth.Unlock()
+
+		if due {
+			if err := auth.reload(); err != nil {
+				fmt.Println("Keeping the old secret:", err)
+			}
+		}
+	}
+
+	auth.Lock()
+	defer auth.Unlock()
+	return auth.secrets
+}
+
+// reload reads the secret file if it changed since it was last read.
+func (auth *Auth) reload() error {
+	auth.Lock()
+	defer auth.Unlock()
+
+	auth.checked = time.Now()
+
+	info, err := os.Stat(auth.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(auth.modTime) && auth.secrets != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(auth.path)
+	if err != nil {
+		return err
+	}
+
+	var secrets [][]byte
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			secrets = append(secrets, []byte(line))
+		}
+	}
+
+	if len(secrets) == 0 {
+		return errors.New("The secret file is empty: " + auth.path)
+	}
+
+	if auth.secrets != nil {
+		fmt.Println("Secret file changed, reloaded it:", auth.path)
+	}
+
+	auth.secrets = secrets
+	auth.modTime = info.ModTime()
+	return nil
+}
+
+// sign returns the signature of a stamp with a secret.
+func sign(secret []byte, stamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
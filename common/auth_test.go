@@ -0,0 +1,108 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbdemo-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewAuth(AuthConfig{SecretPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.CheckHeader(auth.Header()); err != nil {
+		t.Error(err, "A fresh token should pass.")
+	}
+
+	other, _ := NewAuth(AuthConfig{Secret: "wrong"})
+	if err := auth.Check(other.Token()); err != ErrUnauthenticated {
+		t.Error(err, "A token signed with another secret should fail.")
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := auth.Check(stale + "." + sign([]byte("old"), stale)); err != ErrUnauthenticated {
+		t.Error(err, "An old token should fail.")
+	}
+
+	if err := auth.CheckHeader(""); err != ErrUnauthenticated {
+		t.Error(err, "A missing token should fail.")
+	}
+
+	// Rotate the secret. Tokens signed with either are accepted while both
+	//   are in the file, and new tokens use the first.
+	oldToken := auth.Token()
+	if err := os.WriteFile(path, []byte("new\nold\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	time.Sleep(authReload)
+
+	if err := auth.Check(oldToken); err != nil {
+		t.Error(err, "The old secret should still be accepted.")
+	}
+
+	fresh, _ := NewAuth(AuthConfig{Secret: "new"})
+	if err := fresh.Check(auth.Token()); err != nil {
+		t.Error(err, "New tokens should be signed with the new secret.")
+	}
+
+	// Without a secret, calls aren't authenticated.
+	none, err := NewAuth(AuthConfig{})
+	if err != nil || none != nil {
+		t.Error(none, err, "There should be no Auth without a secret.")
+	}
+	if err := none.Check(""); err != nil {
+		t.Error(err, "Everything should pass without an Auth.")
+	}
+}
+
+func TestNodeAuth(t *testing.T) {
+	auth, _ := NewAuth(AuthConfig{Secret: "secret"})
+
+	listener, _, err := Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	node, err := NewNode(listener, &echoWorker{}, time.Second, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	addr := listener.Addr().String()
+
+	var reply string
+	anonymous := NewClient(addr, ClientConfig{})
+	if err := anonymous.Call("Server.Do", "HALT", &reply); err == nil {
+		t.Error("A call without a token should be rejected.")
+	}
+
+	if _, err := DialHTTP(addr, time.Second, ClientConfig{}); err == nil || !errors.Is(err, ErrUnauthenticated) {
+		t.Error(err, "The rejection should say the call was not authenticated.")
+	}
+
+	signed := NewClient(addr, ClientConfig{Auth: auth})
+	defer signed.Close()
+	if err := signed.Call("Server.Do", "work", &reply); err != nil || reply != "work" {
+		t.Error(reply, err, "A signed call should go through.")
+	}
+}
@@ -0,0 +1,261 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without trying when a target has failed too
+//   many times in a row, until its cooldown has passed.
+var ErrCircuitOpen = errors.New("The target is down, not calling it until the cooldown passes.")
+
+// ErrCallTimeout is returned when a call takes longer than its timeout.
+var ErrCallTimeout = errors.New("The call timed out.")
+
+// The client settings used when the config leaves them out.
+const (
+	defaultCallTimeout      = 10 * time.Second
+	defaultFailureThreshold = 5
+	defaultCooldown         = 5 * time.Second
+)
+
+// CallTimeout returns how long a call made with the config waits.
+func (config ClientConfig) CallTimeout() time.Duration {
+	if config.Timeout <= 0 {
+		return defaultCallTimeout
+	}
+
+	return time.Duration(config.Timeout) * time.Millisecond
+}
+
+// NewClient returns a Client for the RPC server at addr. Nothing is dialed
+//   until the first call.
+func NewClient(addr string, config ClientConfig) *Client {
+	client := Client{
+		addr:      addr,
+		timeout:   config.CallTimeout(),
+		threshold: config.FailureThreshold,
+		cooldown:  time.Duration(config.Cooldown) * time.Millisecond,
+	}
+
+	client.dial = func(addr string, timeout time.Duration) (*rpc.Client, error) {
+		return DialHTTP(addr, timeout, config)
+	}
+
+	if client.threshold <= 0 {
+		client.threshold = defaultFailureThreshold
+	}
+	if client.cooldown <= 0 {
+		client.cooldown = defaultCooldown
+	}
+
+	return &client
+}
+
+// A Client is an RPC client that survives its target restarting. A broken
+//   connection is dropped and dialed again on the next call, every call has
+//   a timeout, and a target that keeps failing is left alone for a cooldown
+//   so callers fail fast instead of piling up.
+type Client struct {
+	sync.Mutex
+	addr      string
+	timeout   time.Duration
+	threshold int
+	cooldown  time.Duration
+	dial      func(addr string, timeout time.Duration) (*rpc.Client, error)
+	client    *rpc.Client
+	failures  int
+	openUntil time.Time
+}
+
+// Call calls a method on the target with the client's timeout.
+func (client *Client) Call(method string, args interface{}, reply interface{}) error {
+	return client.CallTimeout(method, args, reply, client.timeout)
+}
+
+// CallTimeout calls a method on the target with its own timeout. A timeout
+//   of zero waits for as long as the target takes, though dialing it still
+//   gives up after the client's timeout. An error the target returned is an
+//   rpc.ServerError, anything else was trouble reaching it.
+func (client *Client) CallTimeout(method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	dialTimeout := timeout
+	if dialTimeout <= 0 {
+		dialTimeout = client.timeout
+	}
+
+	conn, err := client.connect(dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = call(conn, method, args, reply, timeout)
+
+	// A connection that was shut down never sent the call, so it is safe
+	//   to send again on a new one.
+	if err == rpc.ErrShutdown {
+		client.drop(conn)
+
+		conn, err = client.connect(dialTimeout)
+		if err != nil {
+			return err
+		}
+
+		err = call(conn, method, args, reply, timeout)
+	}
+
+	client.record(conn, err)
+	return err
+}
+
+// Connect dials the target now, rather than on the first call.
+func (client *Client) Connect() error {
+	_, err := client.connect(client.timeout)
+	return err
+}
+
+// Close closes the connection. The next call dials again.
+func (client *Client) Close() error {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.client == nil {
+		return nil
+	}
+
+	err := client.client.Close()
+	client.client = nil
+	return err
+}
+
+// String returns the address of the target.
+func (client *Client) String() string {
+	return client.addr
+}
+
+// connect returns the current connection, dialing one if there is none.
+//   While the circuit is open, nothing is dialed.
+func (client *Client) connect(timeout time.Duration) (*rpc.Client, error) {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.client != nil {
+		return client.client, nil
+	}
+
+	if time.Now().Before(client.openUntil) {
+		return nil, ErrCircuitOpen
+	}
+
+	conn, err := client.dial(client.addr, timeout)
+	if err != nil {
+		client.fail()
+		return nil, err
+	}
+
+	client.client = conn
+	return conn, nil
+}
+
+// drop closes a connection if it is still the current one.
+func (client *Client) drop(conn *rpc.Client) {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.client == conn {
+		client.client.Close()
+		client.client = nil
+	}
+}
+
+// record notes how a call went. The target answering, even with an error,
+//   means it is up. Anything else drops the connection and counts towards
+//   opening the circuit.
+func (client *Client) record(conn *rpc.Client, err error) {
+	if _, ok := err.(rpc.ServerError); err == nil || ok {
+		client.Lock()
+		client.failures = 0
+		client.Unlock()
+		return
+	}
+
+	client.drop(conn)
+
+	client.Lock()
+	client.fail()
+	client.Unlock()
+}
+
+// fail counts a failure, and opens the circuit once there are enough in a
+//   row. The lock must be held by the caller.
+func (client *Client) fail() {
+	client.failures++
+	if client.failures >= client.threshold {
+		client.openUntil = time.Now().Add(client.cooldown)
+	}
+}
+
+// call makes a call on a connection, giving up after the timeout unless it
+//   is zero.
+func call(conn *rpc.Client, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	pending := conn.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	if timeout <= 0 {
+		<-pending.Done
+		return pending.Error
+	}
+
+	select {
+	case <-pending.Done:
+		return pending.Error
+	case <-time.After(timeout):
+		return ErrCallTimeout
+	}
+}
+
+// DialHTTP connects to an RPC server over HTTP like rpc.DialHTTP does, but
+//   gives up after the timeout. The connection uses TLS when the config has
+//   it, and the handshake carries a token when the config has an Auth.
+func DialHTTP(addr string, timeout time.Duration, config ClientConfig) (*rpc.Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if config.TLS != nil {
+		secure, err := dialTLS(conn, addr, config.TLS)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = secure
+	}
+
+	handshake := "CONNECT " + rpc.DefaultRPCPath + " HTTP/1.0\n"
+	if config.Auth != nil {
+		handshake += AuthHeader + ": " + config.Auth.Header() + "\n"
+	}
+	io.WriteString(conn, handshake+"\n")
+
+	// The server has to switch to the RPC protocol before anything else.
+	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && response.StatusCode == http.StatusUnauthorized {
+		err = ErrUnauthenticated
+	} else if err == nil && response.Status != "200 Connected to Go RPC" {
+		err = errors.New("Unexpected HTTP response: " + response.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial-http", Net: "tcp " + addr, Addr: nil, Err: err}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type clientService struct {
+}
+
+func (service *clientService) Echo(args string, reply *string) error {
+	if args == "" {
+		return errors.New("Nothing to echo.")
+	}
+
+	*reply = args
+	return nil
+}
+
+func (service *clientService) Sleep(args time.Duration, reply *bool) error {
+	time.Sleep(args)
+	return nil
+}
+
+// serveClientService serves the test service on addr, or any port if addr
+//   is empty.
+func serveClientService(t *testing.T, addr string) net.Listener {
+	if addr == "" {
+		addr = "localhost:0"
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", &clientService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, server)
+	return listener
+}
+
+func TestClient(t *testing.T) {
+	listener := serveClientService(t, "")
+	addr := listener.Addr().String()
+
+	client := NewClient(addr, ClientConfig{Timeout: 100, FailureThreshold: 2, Cooldown: 200})
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Test.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Error(reply, err, "The call did not go through.")
+	}
+
+	// An error from the target doesn't count against it.
+	err := client.Call("Test.Echo", "", &reply)
+	if _, ok := err.(rpc.ServerError); !ok {
+		t.Error(err, "The target's error was not returned as is.")
+	}
+
+	var slept bool
+	if err := client.Call("Test.Sleep", time.Second, &slept); err != ErrCallTimeout {
+		t.Error(err, "The call should have timed out.")
+	}
+
+	// Without a timeout, the call waits for as long as it takes.
+	if err := client.CallTimeout("Test.Sleep", 300*time.Millisecond, &slept, 0); err != nil {
+		t.Error(err, "The call should have waited for the target.")
+	}
+
+	// A timeout drops the connection, so the next call dials a new one.
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the connection. The client should dial again on its own.
+	client.Lock()
+	client.client.Close()
+	client.Unlock()
+
+	if err := client.Call("Test.Echo", "again", &reply); err != nil || reply != "again" {
+		t.Error(reply, err, "The client did not dial again.")
+	}
+
+	// With the target gone, the circuit opens after enough failures.
+	listener.Close()
+	client.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := client.Call("Test.Echo", "down", &reply); err == nil || err == ErrCircuitOpen {
+			t.Error(err, "The call should have failed reaching the target.")
+		}
+	}
+
+	if err := client.Call("Test.Echo", "down", &reply); err != ErrCircuitOpen {
+		t.Error(err, "The circuit should be open.")
+	}
+
+	// Once the target is back and the cooldown has passed, calls go through.
+	listener = serveClientService(t, addr)
+	defer listener.Close()
+	time.Sleep(250 * time.Millisecond)
+
+	if err := client.Call("Test.Echo", "back", &reply); err != nil || reply != "back" {
+		t.Error(reply, err, "The circuit did not close again.")
+	}
+}
@@ -1,17 +1,56 @@
 package common
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"os"
 	"strconv"
+	"time"
 )
 
-// Config stores the data required for the nodes to run.
+// Config stores the data required for the nodes to run. The node ID is
+//   what a node keeps its files under, which unlike its address stays the
+//   same across restarts. Without one, the address is used.
 type Config struct {
 	Address AddressConfig `json:"address"`
 	Irc     IrcConfig     `json:"irc"`
 	Master  MasterConfig  `json:"master"`
 	Node    ConnInfo      `json:"node"`
+	NodeID  string        `json:"nodeId"`
+	Client  ClientConfig  `json:"client"`
+	Auth    AuthConfig    `json:"auth"`
+	TLS     TLSConfig     `json:"tls"`
+}
+
+// ClientConfig stores how calls between tiers are made. The timeout is in
+//   milliseconds, and after failureThreshold failures in a row a target is
+//   left alone for the cooldown, also in milliseconds. Auth and TLS are set
+//   from their own configs when the config is loaded.
+type ClientConfig struct {
+	Timeout          int         `json:"timeout"`
+	FailureThreshold int         `json:"failureThreshold"`
+	Cooldown         int         `json:"cooldown"`
+	Auth             *Auth       `json:"-"`
+	TLS              *tls.Config `json:"-"`
+}
+
+// AuthConfig stores the secret every process in the cluster shares. The
+//   secret file, when there is one, is used instead and is read again when
+//   it changes. Without either, calls are not authenticated.
+type AuthConfig struct {
+	Secret     string `json:"secret"`
+	SecretPath string `json:"secretPath"`
+}
+
+// TLSConfig stores the certificate every listener and dialer in the cluster
+//   uses. Without a certificate, traffic is plaintext. The CA is what the
+//   other end is checked against, and with mutual set, listeners only let in
+//   clients that have a certificate from it too.
+type TLSConfig struct {
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+	CAPath   string `json:"caPath"`
+	Mutual   bool   `json:"mutual"`
 }
 
 type ConnInfo struct {
@@ -31,19 +70,103 @@ type AddressConfig struct {
 	Writer ConnInfo `json:"writer"`
 }
 
-// ConnConfig stores the data required for a node to accept work.
+// ConnConfig stores the data required for a node to accept work. The
+//   control server's services are also served over JSON-RPC on JSONRPC,
+//   unless its port is 0.
 type MasterConfig struct {
-	NodeRegistryPath   string `json:"nodeRegistryPath"`
-	NodeCheckFrequency int    `json:"nodeCheckFrequency"`
+	NodeRegistryPath   string        `json:"nodeRegistryPath"`
+	NodeCheckFrequency int           `json:"nodeCheckFrequency"`
+	Registry           string        `json:"registry"`
+	RegistryTTL        int           `json:"registryTTL"`
+	DrainTimeout       int           `json:"drainTimeout"`
+	ShutdownTimeout    int           `json:"shutdownTimeout"`
+	Restart            RestartConfig `json:"restart"`
+	Control            ConnInfo      `json:"control"`
+	JSONRPC            ConnInfo      `json:"jsonrpc"`
+	SchedulePath       string        `json:"schedulePath"`
+	Launcher           string        `json:"launcher"`
+	Scaling            ScalingConfig `json:"scaling"`
+}
+
+// ScalingConfig stores the targets a master autoscales its nodes towards.
+//   A MaxNodes of zero turns autoscaling off.
+type ScalingConfig struct {
+	MinNodes       int `json:"minNodes"`
+	MaxNodes       int `json:"maxNodes"`
+	CheckFrequency int `json:"checkFrequency"`
+	Checks         int `json:"checks"`
+	Cooldown       int `json:"cooldown"`
+	// ScaleDownRatio is how far under its targets the tier has to be
+	//   before a node is removed.
+	ScaleDownRatio float64 `json:"scaleDownRatio"`
+	// TargetMessages is how many messages each writer should send per
+	//   message window, and MaxQueueWait is how many milliseconds a message
+	//   should wait at most in a writer's queue.
+	TargetMessages int `json:"targetMessages"`
+	MaxQueueWait   int `json:"maxQueueWait"`
+	// TargetUtilization is the share of the readers' channel capacity the
+	//   tier should stay under, between 0 and 1.
+	TargetUtilization float64 `json:"targetUtilization"`
+}
+
+// RestartConfig stores how a node restarts its worker when it fails. The
+//   policy is one of never, on-failure or always, and the backoffs are in
+//   milliseconds.
+type RestartConfig struct {
+	Policy      string `json:"policy"`
+	MaxRestarts int    `json:"maxRestarts"`
+	Backoff     int    `json:"backoff"`
+	MaxBackoff  int    `json:"maxBackoff"`
 }
 
-// IrcConfig stores the data required for a node to use IRC.
+// IrcConfig stores the data required for a node to use IRC. The connection
+//   info is a URL whose scheme picks the transport, see DialIRC, and the
+//   proxy is the URL of the proxy to reach it through, if any. The connect
+//   timeout is how many seconds dialing it may take, see DialTimeout.
+//
+// A writer's spool expiry is how many seconds work is still worth sending,
+//   and its idempotency expiry is how many seconds the result of work sent
+//   with a key is remembered, so a retry is answered rather than sent again.
+//
+// The profiles are the accounts nodes can log in as. Readers log in with
+//   the reader profile, and each writer node leases one of the writer
+//   accounts from the writer master. Sends to a channel in the channel
+//   accounts go out as the account it names. An empty profile name means
+//   the nickname and password.
 type IrcConfig struct {
-	MessageLimit  int    `json:"messageLimit"`
-	ReadFrequency int    `json:"readFrequency"`
-	Nickname      string `json:"nickname"`
-	Password      string `json:"password"`
-	ConnInfo      string `json:"connectionInfo"`
+	MessageLimit          int    `json:"messageLimit"`
+	ModeratorMessageLimit int    `json:"moderatorMessageLimit"`
+	MessageWindow         int    `json:"messageWindow"`
+	NoticeWindow          int    `json:"noticeWindow"`
+	DuplicatePolicy       string `json:"duplicatePolicy"`
+	SpoolPath             string `json:"spoolPath"`
+	SpoolExpiry           int    `json:"spoolExpiry"`
+	IdempotencyExpiry     int    `json:"idempotencyExpiry"`
+	MaxChannels           int    `json:"maxChannels"`
+	ReadFrequency         int    `json:"readFrequency"`
+	Nickname              string `json:"nickname"`
+	Password              string `json:"password"`
+	ConnInfo              string `json:"connectionInfo"`
+	Proxy                 string `json:"proxy"`
+	ConnectTimeout        int    `json:"connectTimeout"`
+
+	Profiles        map[string]CredentialConfig `json:"profiles"`
+	ReaderProfile   string                      `json:"readerProfile"`
+	WriterAccounts  []string                    `json:"writerAccounts"`
+	ChannelAccounts map[string]string           `json:"channelAccounts"`
+}
+
+// The time dialing the IRC server may take when the config leaves it out.
+const defaultConnectTimeout = 10 * time.Second
+
+// DialTimeout returns how long dialing the IRC server may take, proxy and
+//   handshakes included.
+func (config IrcConfig) DialTimeout() time.Duration {
+	if config.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+
+	return time.Duration(config.ConnectTimeout) * time.Second
 }
 
 // LoadConfig returns the configuration read into a Config struct.
@@ -61,5 +184,20 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
+	err = config.Irc.checkProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Client.Auth, err = NewAuth(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Client.TLS, err = NewClientTLS(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+)
+
+// A ControlServer hosts the RPC services a master provides on top of
+//   its load balancer.
+type ControlServer struct {
+	sync.Mutex
+	addr    string
+	server  *rpc.Server
+	auth    *Auth
+	tls     *tls.Config
+	serving []*http.Server
+	closed  bool
+}
+
+// NewControlServer returns a ControlServer that will listen on connInfo.
+//   With an Auth, calls without a valid token are turned away, and with a
+//   TLS config, connections are served over TLS.
+func NewControlServer(connInfo ConnInfo, auth *Auth, config *tls.Config) *ControlServer {
+	return &ControlServer{
+		addr:   connInfo.String(),
+		server: rpc.NewServer(),
+		auth:   auth,
+		tls:    config,
+	}
+}
+
+// Register publishes the methods of service under the given name.
+func (control *ControlServer) Register(name string, service interface{}) error {
+	return control.server.RegisterName(name, service)
+}
+
+// ListenAndServe accepts RPC connections until an error occurs or the
+//   server is closed.
+func (control *ControlServer) ListenAndServe() error {
+	return control.serve(control.addr, control.server)
+}
+
+// ListenAndServeJSON serves the same services over JSON-RPC 2.0 on HTTP at
+//   connInfo, with the same auth and TLS, until an error occurs or the
+//   server is closed.
+func (control *ControlServer) ListenAndServeJSON(connInfo ConnInfo) error {
+	return control.serve(connInfo.String(), NewJSONHandler(control.server))
+}
+
+// Close stops the server from accepting connections, which ends
+//   ListenAndServe and ListenAndServeJSON without an error.
+func (control *ControlServer) Close() error {
+	control.Lock()
+	defer control.Unlock()
+
+	control.closed = true
+
+	var errs []error
+	for _, server := range control.serving {
+		if err := server.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// serve accepts connections on addr for handler until an error occurs or
+//   the server is closed.
+func (control *ControlServer) serve(addr string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if control.tls != nil {
+		listener = tls.NewListener(listener, control.tls)
+	}
+
+	server := &http.Server{Handler: control.auth.Handler(handler)}
+
+	control.Lock()
+	if control.closed {
+		control.Unlock()
+		listener.Close()
+		return nil
+	}
+	control.serving = append(control.serving, server)
+	control.Unlock()
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
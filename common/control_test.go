@@ -0,0 +1,46 @@
+package common
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestControlServerClose(t *testing.T) {
+	port, err := GetOpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connInfo := ConnInfo{Hostname: "localhost", Port: port}
+	control := NewControlServer(connInfo, nil, nil)
+
+	served := make(chan error, 1)
+	go func() { served <- control.ListenAndServe() }()
+
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", connInfo.String()); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := control.Close(); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err, "Closing the server is not an error.")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Closing the server did not end ListenAndServe.")
+	}
+
+	// A server that was closed does not start serving again.
+	if err := control.ListenAndServe(); err != nil {
+		t.Error(err, "A closed server should stop right away.")
+	}
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+)
+
+// The nickname Twitch lets anyone read chat as, without a password, once
+//   some digits are added to it.
+const anonymousNickname = "justinfan"
+
+// CredentialConfig is an account to log in to IRC as. An anonymous profile
+//   needs no nickname or password, and can read chat but not send to it.
+type CredentialConfig struct {
+	Nickname  string `json:"nickname"`
+	Password  string `json:"password"`
+	Anonymous bool   `json:"anonymous"`
+}
+
+// Login returns the lines that log in to IRC with the credentials. An
+//   anonymous login picks a justinfan nickname and sends no password.
+func (credentials CredentialConfig) Login() []string {
+	if credentials.Anonymous {
+		return []string{"NICK " + anonymousNickname + strconv.Itoa(10000+rand.Intn(90000))}
+	}
+
+	return []string{
+		"PASS " + credentials.Password,
+		"NICK " + credentials.Nickname,
+	}
+}
+
+// Credentials returns the named profile. An empty name is the nickname and
+//   password on the IRC config itself, so configs from before profiles keep
+//   working.
+func (config *IrcConfig) Credentials(profile string) (CredentialConfig, error) {
+	if profile == "" {
+		return CredentialConfig{Nickname: config.Nickname, Password: config.Password}, nil
+	}
+
+	credentials, ok := config.Profiles[profile]
+	if !ok {
+		return CredentialConfig{}, errors.New("No credential profile is named: " + profile)
+	}
+
+	return credentials, nil
+}
+
+// IsWriterAccount returns whether a profile is in the pool of writer
+//   accounts.
+func (config *IrcConfig) IsWriterAccount(profile string) bool {
+	for _, account := range config.WriterAccounts {
+		if account == profile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkProfiles makes sure every profile the config names exists, and that
+//   writers are never asked to send as an anonymous one.
+func (config *IrcConfig) checkProfiles() error {
+	if _, err := config.Credentials(config.ReaderProfile); err != nil {
+		return err
+	}
+
+	writers := make(map[string]bool)
+	for _, account := range config.WriterAccounts {
+		credentials, err := config.Credentials(account)
+		if err != nil {
+			return err
+		}
+		if account == "" || credentials.Anonymous {
+			return errors.New("A writer account has to be a profile that can send: " + account)
+		}
+		if writers[account] {
+			return errors.New("A writer account is in the pool twice: " + account)
+		}
+		writers[account] = true
+	}
+
+	for channel, account := range config.ChannelAccounts {
+		if !writers[account] {
+			return errors.New("The account for " + channel + " is not a writer account: " + account)
+		}
+	}
+
+	return nil
+}
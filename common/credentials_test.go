@@ -0,0 +1,85 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCredentials(t *testing.T) {
+	config := IrcConfig{
+		Nickname: "legacy",
+		Password: "oauth:legacy",
+		Profiles: map[string]CredentialConfig{
+			"bot":       {Nickname: "bot", Password: "oauth:bot"},
+			"anonymous": {Anonymous: true},
+		},
+	}
+
+	login := func(profile string) []string {
+		credentials, err := config.Credentials(profile)
+		if err != nil {
+			t.Fatal(profile, err)
+		}
+		return credentials.Login()
+	}
+
+	// The nickname and password are still used without a profile.
+	if lines := login(""); !reflect.DeepEqual(lines, []string{"PASS oauth:legacy", "NICK legacy"}) {
+		t.Error(lines, "The config's own login was not used.")
+	}
+
+	if lines := login("bot"); !reflect.DeepEqual(lines, []string{"PASS oauth:bot", "NICK bot"}) {
+		t.Error(lines, "The profile's login was not used.")
+	}
+
+	lines := login("anonymous")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "NICK justinfan") || len(lines[0]) == len("NICK justinfan") {
+		t.Error(lines, "An anonymous login should be a justinfan without a password.")
+	}
+
+	if _, err := config.Credentials("missing"); err == nil {
+		t.Error("An unknown profile should not be found.")
+	}
+}
+
+func TestCheckProfiles(t *testing.T) {
+	profiles := map[string]CredentialConfig{
+		"bot":       {Nickname: "bot", Password: "oauth:bot"},
+		"brand":     {Nickname: "brand", Password: "oauth:brand"},
+		"anonymous": {Anonymous: true},
+	}
+
+	good := IrcConfig{
+		Profiles:        profiles,
+		ReaderProfile:   "anonymous",
+		WriterAccounts:  []string{"bot", "brand"},
+		ChannelAccounts: map[string]string{"#brand": "brand"},
+	}
+	if err := good.checkProfiles(); err != nil {
+		t.Error(err)
+	}
+
+	if err := (&IrcConfig{}).checkProfiles(); err != nil {
+		t.Error(err, "A config without profiles should still be fine.")
+	}
+
+	bad := map[string]IrcConfig{
+		"unknown reader":     {Profiles: profiles, ReaderProfile: "missing"},
+		"unknown writer":     {Profiles: profiles, WriterAccounts: []string{"missing"}},
+		"anonymous writer":   {Profiles: profiles, WriterAccounts: []string{"anonymous"}},
+		"empty writer":       {Profiles: profiles, WriterAccounts: []string{""}},
+		"repeated writer":    {Profiles: profiles, WriterAccounts: []string{"bot", "bot"}},
+		"channel not pooled": {Profiles: profiles, WriterAccounts: []string{"bot"}, ChannelAccounts: map[string]string{"#brand": "brand"}},
+	}
+
+	for name, config := range bad {
+		if err := config.checkProfiles(); err == nil {
+			t.Error(name, "The config should have been refused.")
+		}
+	}
+
+	if !good.IsWriterAccount("brand") || good.IsWriterAccount("anonymous") {
+		t.Error("The pool of writer accounts was not as expected.")
+	}
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// NewGateway returns a Gateway for a load balancer that should be reached
+//   on public, which is bound right away so no other process can take it.
+//   The load balancer itself is moved to a loopback port, which Target
+//   returns. That port is bound and held by the gateway too, until Serve
+//   hands it over to the load balancer. Without an Auth or a TLS config
+//   there is nothing for a gateway to do, so nil is returned.
+func NewGateway(public ConnInfo, auth *Auth, config *tls.Config) (*Gateway, error) {
+	if auth == nil && config == nil {
+		return nil, nil
+	}
+
+	listener, _, err := Listen(public.Hostname, public.Port, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the loopback address, so the load balancer can't be reached
+	//   from other hosts without going through the gateway.
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &Gateway{
+		target:   ConnInfo{"127.0.0.1", reserved.Addr().(*net.TCPAddr).Port},
+		auth:     auth,
+		listener: listener,
+		reserved: reserved,
+	}, nil
+}
+
+// A Gateway checks the token of every connection to a load balancer and
+//   ends its TLS, since the load balancer's own server does neither. A
+//   connection with a valid token is passed through as is.
+type Gateway struct {
+	target   ConnInfo
+	auth     *Auth
+	listener net.Listener
+	reserved net.Listener
+}
+
+// Target returns the address the load balancer should listen on.
+func (gateway *Gateway) Target() ConnInfo {
+	return gateway.target
+}
+
+// Serve returns a job that lets go of the target port and then runs listen,
+//   which should be the load balancer's ListenAndServe. The load balancer
+//   binds the port on its own, as it only takes a host and port, so the
+//   port is held right up until then. Should another process take it in
+//   between, listen fails, and with it the master, so calls are never
+//   passed to anything but the load balancer.
+func (gateway *Gateway) Serve(listen func() error) func() error {
+	return func() error {
+		gateway.reserved.Close()
+		return listen()
+	}
+}
+
+// ListenAndServe accepts connections on the public address until an error
+//   occurs. The address was bound when the gateway was created, so this
+//   only serves.
+func (gateway *Gateway) ListenAndServe() error {
+	for {
+		conn, err := gateway.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go gateway.serve(conn)
+	}
+}
+
+// Close stops the gateway from accepting connections, which ends
+//   ListenAndServe, and lets go of the target port if Serve never did.
+func (gateway *Gateway) Close() error {
+	gateway.reserved.Close()
+	return gateway.listener.Close()
+}
+
+// serve checks the handshake of a connection, and then passes it through to
+//   the load balancer.
+func (gateway *Gateway) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+
+	if err := gateway.auth.CheckHeader(request.Header.Get(AuthHeader)); err != nil {
+		fmt.Println("Rejected an unauthenticated call:", conn.RemoteAddr())
+		io.WriteString(conn, "HTTP/1.0 401 Unauthorized\n\n")
+		return
+	}
+
+	target, err := net.Dial("tcp", gateway.target.String())
+	if err != nil {
+		fmt.Println("Gateway could not reach the load balancer:", err)
+		io.WriteString(conn, "HTTP/1.0 502 Bad Gateway\n\n")
+		return
+	}
+	defer target.Close()
+
+	// The load balancer gets the same handshake, just without the token.
+	io.WriteString(target, request.Method+" "+request.RequestURI+" HTTP/1.0\n\n")
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+
+	// Once either side is done, the deferred closes end the other.
+	<-done
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	auth, _ := NewAuth(AuthConfig{Secret: "secret"})
+
+	port, err := GetOpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gateway, err := NewGateway(ConnInfo{"localhost", port}, auth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gateway.ListenAndServe()
+	defer gateway.Close()
+
+	// Stand in for a load balancer, which knows nothing of tokens.
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", &clientService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The target is held until the load balancer is started.
+	target := gateway.Target()
+	if _, err := net.Listen("tcp", target.String()); err == nil {
+		t.Error("The target should be held by the gateway until Serve.")
+	}
+
+	listening := make(chan net.Listener)
+	go gateway.Serve(func() error {
+		listener, err := net.Listen("tcp", target.String())
+		if err != nil {
+			close(listening)
+			return err
+		}
+		listening <- listener
+		return http.Serve(listener, server)
+	})()
+
+	listener, ok := <-listening
+	if !ok {
+		t.Fatal("The load balancer should be able to take the target once served.")
+	}
+	defer listener.Close()
+
+	// The public address is bound as soon as the gateway is made.
+	public := ConnInfo{"localhost", port}
+	if _, err := net.Listen("tcp", public.String()); err == nil {
+		t.Error("The public address should already be taken by the gateway.")
+	}
+
+	if _, err := DialHTTP(public.String(), time.Second, ClientConfig{}); !errors.Is(err, ErrUnauthenticated) {
+		t.Error(err, "The gateway should turn away a call without a token.")
+	}
+
+	client := NewClient(public.String(), ClientConfig{Auth: auth})
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Test.Echo", "through", &reply); err != nil || reply != "through" {
+		t.Error(reply, err, "A signed call should pass through the gateway.")
+	}
+
+	// Without a secret there is nothing for a gateway to do.
+	if none, err := NewGateway(public, nil, nil); none != nil || err != nil {
+		t.Error(none, err, "There should be no gateway without an Auth.")
+	}
+}
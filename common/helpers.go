@@ -40,6 +40,9 @@ func (slice *AtomicStringSlice) Has(str string) bool {
 
 // List returns a copy of every element in the AtomicStringSlice
 func (slice *AtomicStringSlice) List() []string {
+	slice.Lock()
+	defer slice.Unlock()
+
 	duplicate := make([]string, len(slice.s))
 	copy(duplicate, slice.s)
 	return duplicate
@@ -0,0 +1,330 @@
+package common
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An IRCConn is a connection to an IRC server, whatever it runs over.
+type IRCConn interface {
+	// ReadLine blocks until there is a whole line, and returns it with its
+	//   line ending. It returns io.EOF once the server has gone away.
+	ReadLine() (string, error)
+	// WriteLine sends a line, adding the line ending.
+	WriteLine(line string) error
+	Close() error
+}
+
+// The ports used when the connection URL leaves them out.
+var ircPorts = map[string]string{
+	"irc":  "6667",
+	"ircs": "6697",
+	"wss":  "443",
+}
+
+// DialIRC connects to the IRC server at rawURL, choosing the transport from
+//   its scheme: irc:// is plain TCP, ircs:// is TLS, and wss:// is chat over
+//   a WebSocket, like Twitch's irc-ws.chat.twitch.tv. A bare host:port is
+//   taken to be irc://. The connection goes through the proxy at proxyURL
+//   unless it is empty, see dialProxy. The TLS config may be nil to use the
+//   system's roots. Dialing gives up after timeout, handshakes included.
+func DialIRC(rawURL string, proxyURL string, config *tls.Config, timeout time.Duration) (IRCConn, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "irc://" + rawURL
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	port, ok := ircPorts[target.Scheme]
+	if !ok {
+		return nil, errors.New("Unknown IRC transport: " + target.Scheme)
+	}
+	if target.Port() != "" {
+		port = target.Port()
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+
+	deadline := time.Now().Add(timeout)
+	raw, err := dialProxy(proxyURL, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	irc, err := handshakeIRC(raw, target, addr, config, deadline)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	return irc, nil
+}
+
+// handshakeIRC sets up the transport on a connection that was just dialed,
+//   and has until deadline to do so.
+func handshakeIRC(conn net.Conn, target *url.URL, addr string, config *tls.Config, deadline time.Time) (IRCConn, error) {
+	raw := conn
+	if err := raw.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if target.Scheme != "irc" {
+		if config == nil {
+			config = &tls.Config{}
+		}
+
+		secure, err := dialTLS(conn, addr, config)
+		if err != nil {
+			return nil, err
+		}
+		conn = secure
+	}
+
+	var irc IRCConn = &lineConn{conn: conn, reader: bufio.NewReader(conn)}
+	if target.Scheme == "wss" {
+		var err error
+		if irc, err = dialWebSocket(conn, target); err != nil {
+			return nil, err
+		}
+	}
+
+	// Reads wait as long as it takes once connected.
+	if err := raw.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return irc, nil
+}
+
+// A lineConn is IRC straight over a stream, plain or TLS.
+type lineConn struct {
+	sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (irc *lineConn) ReadLine() (string, error) {
+	return irc.reader.ReadString('\n')
+}
+
+func (irc *lineConn) WriteLine(line string) error {
+	irc.Lock()
+	defer irc.Unlock()
+
+	_, err := io.WriteString(irc.conn, line+"\r\n")
+	return err
+}
+
+func (irc *lineConn) Close() error {
+	return irc.conn.Close()
+}
+
+// The WebSocket opcodes that are used.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// The largest frame that is read.
+const wsMaxFrame = 1 << 20
+
+// The GUID a WebSocket server proves it read the key with.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// dialWebSocket opens a WebSocket over a connection that was just dialed.
+func dialWebSocket(conn net.Conn, target *url.URL) (IRCConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	path := target.RequestURI()
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\n"+
+		"Host: "+target.Host+"\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err == nil && response.StatusCode != http.StatusSwitchingProtocols {
+		err = errors.New("Unexpected WebSocket response: " + response.Status)
+	}
+	if err == nil && response.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		err = errors.New("The WebSocket server did not accept the key.")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: reader}, nil
+}
+
+// wsAccept returns what a server answers a WebSocket key with.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// A wsConn is IRC over a WebSocket. A text message can hold any number of
+//   lines, and each line written is sent as a message of its own.
+type wsConn struct {
+	sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	pending string
+}
+
+func (irc *wsConn) ReadLine() (string, error) {
+	for {
+		if i := strings.IndexByte(irc.pending, '\n'); i >= 0 {
+			line := irc.pending[:i+1]
+			irc.pending = irc.pending[i+1:]
+			return line, nil
+		}
+
+		opcode, payload, err := readFrame(irc.reader)
+		if err != nil {
+			return "", err
+		}
+
+		switch opcode {
+		case wsText, wsContinuation:
+			irc.pending += string(payload)
+		case wsPing:
+			irc.write(wsPong, payload)
+		case wsClose:
+			irc.write(wsClose, nil)
+			return "", io.EOF
+		}
+	}
+}
+
+func (irc *wsConn) WriteLine(line string) error {
+	return irc.write(wsText, []byte(line+"\r\n"))
+}
+
+func (irc *wsConn) Close() error {
+	irc.write(wsClose, nil)
+	return irc.conn.Close()
+}
+
+// write sends a frame. Frames from a client are always masked.
+func (irc *wsConn) write(opcode byte, payload []byte) error {
+	irc.Lock()
+	defer irc.Unlock()
+
+	return writeFrame(irc.conn, opcode, payload, true)
+}
+
+// readFrame reads a single frame, and unmasks its payload if it is masked.
+func readFrame(reader *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > wsMaxFrame {
+		return 0, nil, errors.New("The WebSocket frame is too large.")
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(reader, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+
+	return opcode, payload, nil
+}
+
+// writeFrame writes a payload as a single final frame.
+func writeFrame(writer io.Writer, opcode byte, payload []byte, masked bool) error {
+	frame := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	body := payload
+	if masked {
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		frame = append(frame, mask...)
+
+		body = make([]byte, length)
+		for i := range payload {
+			body[i] = payload[i] ^ mask[i%4]
+		}
+	}
+
+	_, err := writer.Write(append(frame, body...))
+	return err
+}
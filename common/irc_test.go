@@ -0,0 +1,203 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveLines answers every line sent to the listener with "echo " and the
+//   line, after greeting the client.
+func serveLines(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			io.WriteString(conn, ":tmi.twitch.tv 001 nick :Welcome\r\n")
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, "echo "+line)
+			}
+		}(conn)
+	}
+}
+
+// checkEcho has a conversation with a server run by serveLines.
+func checkEcho(t *testing.T, conn IRCConn) {
+	defer conn.Close()
+
+	if line, err := conn.ReadLine(); err != nil || line != ":tmi.twitch.tv 001 nick :Welcome\r\n" {
+		t.Error(line, err, "The greeting was not read.")
+	}
+
+	if err := conn.WriteLine("PING :tmi.twitch.tv"); err != nil {
+		t.Error(err)
+	}
+
+	if line, err := conn.ReadLine(); err != nil || line != "echo PING :tmi.twitch.tv\r\n" {
+		t.Error(line, err, "The line did not make the round trip.")
+	}
+}
+
+func TestDialIRC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveLines(listener)
+
+	// A bare address is plain IRC.
+	conn, err := DialIRC(listener.Addr().String(), "", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	conn, err = DialIRC("irc://"+listener.Addr().String(), "", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	if _, err := DialIRC("gopher://"+listener.Addr().String(), "", nil, 5*time.Second); err == nil {
+		t.Error("An unknown transport should be refused.")
+	}
+}
+
+func TestDialIRCTLS(t *testing.T) {
+	// The test server provides a certificate for 127.0.0.1.
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	config := &tls.Config{RootCAs: roots}
+
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := tls.NewListener(plain, server.TLS)
+	defer listener.Close()
+	go serveLines(listener)
+
+	conn, err := DialIRC("ircs://"+listener.Addr().String(), "", config, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	// A server that can't be verified is refused.
+	if _, err := DialIRC("ircs://"+listener.Addr().String(), "", nil, 5*time.Second); err == nil {
+		t.Error("An untrusted certificate should be refused.")
+	}
+
+	// A server that never answers the handshake is given up on.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	start := time.Now()
+	if _, err := DialIRC("ircs://"+silent.Addr().String(), "", config, 100*time.Millisecond); err == nil {
+		t.Error("A handshake that never finishes should fail.")
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Error(waited, "Dialing should give up after its timeout.")
+	}
+}
+
+func TestDialIRCWebSocket(t *testing.T) {
+	received := make(chan []byte, 4)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat" || r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "Not a WebSocket.", http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+wsAccept(r.Header.Get("Sec-WebSocket-Key"))+"\r\n\r\n")
+
+		// Twitch can put more than one line in a message.
+		writeFrame(conn, wsText, []byte(":tmi.twitch.tv 001 nick :Welcome\r\nPING :tmi.twitch.tv\r\n"), false)
+		writeFrame(conn, wsPing, []byte("still there?"), false)
+
+		for i := 0; i < 2; i++ {
+			opcode, payload, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			received <- append([]byte{opcode}, payload...)
+		}
+
+		writeFrame(conn, wsClose, nil, false)
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	config := &tls.Config{RootCAs: roots}
+
+	addr := strings.TrimPrefix(server.URL, "https://")
+	conn, err := DialIRC("wss://"+addr+"/chat", "", config, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if line, err := conn.ReadLine(); err != nil || line != ":tmi.twitch.tv 001 nick :Welcome\r\n" {
+		t.Error(line, err, "The first line of the message was not read.")
+	}
+	if line, err := conn.ReadLine(); err != nil || line != "PING :tmi.twitch.tv\r\n" {
+		t.Error(line, err, "The second line of the message was not read.")
+	}
+
+	if err := conn.WriteLine("PONG :tmi.twitch.tv"); err != nil {
+		t.Error(err)
+	}
+
+	// The ping is answered while reading, and the close ends the reading.
+	if _, err := conn.ReadLine(); err != io.EOF {
+		t.Error(err, "A closed WebSocket should read as the end.")
+	}
+
+	frames := map[byte]string{}
+	for i := 0; i < 2; i++ {
+		frame := <-received
+		frames[frame[0]] = string(frame[1:])
+	}
+
+	if frames[wsText] != "PONG :tmi.twitch.tv\r\n" {
+		t.Error(frames, "The line was not sent as a message.")
+	}
+	if frames[wsPong] != "still there?" {
+		t.Error(frames, "The ping was not answered.")
+	}
+}
@@ -0,0 +1,194 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/rpc"
+	"strings"
+)
+
+// The error codes JSON-RPC 2.0 defines, and the one used for errors
+//   returned by a method.
+const (
+	jsonParseError     = -32700
+	jsonInvalidRequest = -32600
+	jsonMethodNotFound = -32601
+	jsonInvalidParams  = -32602
+	jsonServerError    = -32000
+)
+
+// The largest request body that is read.
+const jsonMaxBody = 1 << 20
+
+// NewJSONHandler returns a handler that serves the methods published on
+//   server over JSON-RPC 2.0, for clients that don't speak Go's RPC. Every
+//   call goes to the same method the Go client would reach, so they behave
+//   the same. A request's params are the method's argument, either as is or
+//   as the only item of an array.
+func NewJSONHandler(server *rpc.Server) http.Handler {
+	return &jsonHandler{server}
+}
+
+type jsonHandler struct {
+	server *rpc.Server
+}
+
+type jsonRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonError      `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ServeHTTP answers a single request or a batch of them.
+func (handler *jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests have to be POSTed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, jsonMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			result = jsonFailure(nil, jsonParseError, "The batch could not be parsed.")
+		} else {
+			var responses []*jsonResponse
+			for _, raw := range batch {
+				if response := handler.call(raw); response != nil {
+					responses = append(responses, response)
+				}
+			}
+			if len(responses) > 0 {
+				result = responses
+			}
+		}
+	} else if response := handler.call(body); response != nil {
+		result = response
+	}
+
+	// A request without an id is a notification, and gets no reply.
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// call makes a single call, and returns the response to send, if any.
+func (handler *jsonHandler) call(raw json.RawMessage) *jsonResponse {
+	var request jsonRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return jsonFailure(nil, jsonParseError, "The request could not be parsed.")
+	}
+
+	if request.Version != "2.0" || request.Method == "" {
+		return jsonFailure(request.ID, jsonInvalidRequest, "The request is not JSON-RPC 2.0.")
+	}
+
+	codec := &jsonCodec{request: request}
+	err := handler.server.ServeRequest(codec)
+
+	if request.ID == nil {
+		return nil
+	}
+
+	if codec.failure != "" {
+		// The rpc package's own errors are about finding the method, any
+		//   other error before the call is about decoding the params.
+		code := jsonServerError
+		if err != nil && strings.HasPrefix(codec.failure, "rpc: ") {
+			code = jsonMethodNotFound
+		} else if err != nil {
+			code = jsonInvalidParams
+		}
+
+		return jsonFailure(request.ID, code, codec.failure)
+	}
+
+	return &jsonResponse{Version: "2.0", Result: codec.result, ID: request.ID}
+}
+
+// jsonFailure returns an error response.
+func jsonFailure(id json.RawMessage, code int, message string) *jsonResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &jsonResponse{Version: "2.0", Error: &jsonError{code, message}, ID: id}
+}
+
+// A jsonCodec hands a single JSON-RPC request to an rpc.Server and keeps
+//   what it replies.
+type jsonCodec struct {
+	request jsonRequest
+	result  interface{}
+	failure string
+}
+
+func (codec *jsonCodec) ReadRequestHeader(request *rpc.Request) error {
+	request.ServiceMethod = codec.request.Method
+	request.Seq = 0
+	return nil
+}
+
+func (codec *jsonCodec) ReadRequestBody(args interface{}) error {
+	if args == nil {
+		return nil
+	}
+
+	params := codec.request.Params
+	if len(params) == 0 {
+		return errors.New("The request has no params.")
+	}
+
+	// Positional params are taken to be the argument in an array.
+	if params[0] == '[' {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err == nil && len(positional) == 1 {
+			params = positional[0]
+		}
+	}
+
+	return json.Unmarshal(params, args)
+}
+
+func (codec *jsonCodec) WriteResponse(response *rpc.Response, result interface{}) error {
+	if response.Error != "" {
+		codec.failure = response.Error
+		return nil
+	}
+
+	codec.result = result
+	return nil
+}
+
+func (codec *jsonCodec) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+// postJSON sends a JSON-RPC body and returns the status and the decoded
+//   reply.
+func postJSON(t *testing.T, url string, body string) (int, interface{}) {
+	response, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var reply interface{}
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return response.StatusCode, reply
+}
+
+// errorCode returns the error code of a decoded reply, or 0 without one.
+func errorCode(reply interface{}) int {
+	failure, ok := reply.(map[string]interface{})["error"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	return int(failure["code"].(float64))
+}
+
+func TestJSONHandler(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", &clientService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, _ := NewAuth(AuthConfig{Secret: "secret"})
+	guarded := httptest.NewServer(auth.Handler(NewJSONHandler(server)))
+	defer guarded.Close()
+
+	// The same auth as the Go clients applies.
+	status, _ := postJSON(t, guarded.URL, `{"jsonrpc": "2.0", "method": "Test.Echo", "params": "hi", "id": 1}`)
+	if status != 401 {
+		t.Error(status, "An unauthenticated call should be turned away.")
+	}
+
+	open := httptest.NewServer(NewJSONHandler(server))
+	defer open.Close()
+
+	_, reply := postJSON(t, open.URL, `{"jsonrpc": "2.0", "method": "Test.Echo", "params": ["hi"], "id": 1}`)
+	if result := reply.(map[string]interface{})["result"]; result != "hi" {
+		t.Error(reply, "The call did not go through.")
+	}
+
+	// Errors from the method are passed on, just as over Go's RPC.
+	_, reply = postJSON(t, open.URL, `{"jsonrpc": "2.0", "method": "Test.Echo", "params": "", "id": 2}`)
+	if errorCode(reply) != jsonServerError {
+		t.Error(reply, "The method's error was not returned.")
+	}
+
+	_, reply = postJSON(t, open.URL, `{"jsonrpc": "2.0", "method": "Test.Missing", "params": "", "id": 3}`)
+	if errorCode(reply) != jsonMethodNotFound {
+		t.Error(reply, "A missing method should be reported as such.")
+	}
+
+	_, reply = postJSON(t, open.URL, `{"jsonrpc": "2.0", "method": "Test.Echo", "params": 5, "id": 4}`)
+	if errorCode(reply) != jsonInvalidParams {
+		t.Error(reply, "Params of the wrong type should be reported as such.")
+	}
+
+	_, reply = postJSON(t, open.URL, `{"method": "Test.Echo", "params": "hi", "id": 5}`)
+	if errorCode(reply) != jsonInvalidRequest {
+		t.Error(reply, "A request that isn't JSON-RPC 2.0 should be rejected.")
+	}
+
+	// A notification gets no reply, and neither does it in a batch.
+	status, _ = postJSON(t, open.URL, `{"jsonrpc": "2.0", "method": "Test.Echo", "params": "hi"}`)
+	if status != http.StatusNoContent {
+		t.Error(status, "A notification should get no reply.")
+	}
+
+	_, reply = postJSON(t, open.URL, `[
+		{"jsonrpc": "2.0", "method": "Test.Echo", "params": "a", "id": 1},
+		{"jsonrpc": "2.0", "method": "Test.Echo", "params": "b"},
+		{"jsonrpc": "2.0", "method": "Test.Echo", "params": "c", "id": 3}
+	]`)
+	if batch, ok := reply.([]interface{}); !ok || len(batch) != 2 {
+		t.Error(reply, "A batch should get a reply for every call but the notification.")
+	}
+}
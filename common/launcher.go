@@ -0,0 +1,500 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Launcher starts and stops nodes on behalf of a master. Nodes are named
+//   by the address they listen on.
+type Launcher interface {
+	Launch() (string, error)
+	Stop(node string) error
+	Nodes() []string
+}
+
+// NewLauncher returns the Launcher the config asks for. Without one, nodes
+//   are left to an operator.
+func NewLauncher(config *Config) (Launcher, error) {
+	switch config.Master.Launcher {
+	case "", "manual":
+		return NewManualLauncher(), nil
+	case "process":
+		return NewProcessLauncher(config)
+	default:
+		return nil, errors.New("Unknown launcher: " + config.Master.Launcher)
+	}
+}
+
+// NewManualLauncher returns a Launcher that leaves the work to an operator.
+func NewManualLauncher() Launcher {
+	return &ManualLauncher{}
+}
+
+// A ManualLauncher can't start or stop anything itself. It logs what it was
+//   asked to do so an operator can do it instead.
+type ManualLauncher struct {
+}
+
+// Launch asks for a node to be started.
+func (launcher *ManualLauncher) Launch() (string, error) {
+	fmt.Println("Another node is needed, please start one.")
+	return "", nil
+}
+
+// Stop asks for a node to be stopped.
+func (launcher *ManualLauncher) Stop(node string) error {
+	fmt.Println("A node is no longer needed, please stop:", node)
+	return nil
+}
+
+// Nodes returns nothing since no nodes are managed.
+func (launcher *ManualLauncher) Nodes() []string {
+	return nil
+}
+
+// StopNodes stops every node a launcher manages, for when the master itself
+//   is shutting down. The nodes drain at the same time. A launcher that
+//   keeps files for its nodes is closed after, which deletes them.
+func StopNodes(launcher Launcher) error {
+	nodes := launcher.Nodes()
+	errs := make([]error, len(nodes))
+
+	var stopping sync.WaitGroup
+	for i, node := range nodes {
+		stopping.Add(1)
+		go func(i int, node string) {
+			defer stopping.Done()
+			errs[i] = launcher.Stop(node)
+		}(i, node)
+	}
+	stopping.Wait()
+
+	if closer, ok := launcher.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// How long a stopped node gets to exit on its own before it is killed, how
+//   long to wait before restarting one that crashed when the restart policy
+//   has no backoff, and how long a started node gets to report its address.
+const (
+	stopTimeout   = 10 * time.Second
+	restartDelay  = time.Second
+	launchTimeout = 10 * time.Second
+	addressPoll   = 50 * time.Millisecond
+)
+
+// AddressEnv names the environment variable that tells a launched node
+//   which file to report its address in.
+const AddressEnv = "LBDEMO_ADDRESS_PATH"
+
+// ReportAddress tells the launcher that started this node, if one did, the
+//   address the node is listening on. The file is replaced in one go so the
+//   launcher never reads half of it.
+func ReportAddress(node string) error {
+	path := os.Getenv(AddressEnv)
+	if path == "" {
+		return nil
+	}
+
+	if err := os.WriteFile(path+".tmp", []byte(node), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// NewProcessLauncher returns a Launcher that runs nodes as child processes
+//   of the current binary. Each child gets its own copy of the config, and
+//   is restarted under the same policy as a node's own jobs.
+func NewProcessLauncher(config *Config) (*ProcessLauncher, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "lbdemo-nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	policy := NewRestartPolicy(config.Master.Restart)
+	if policy.Backoff <= 0 {
+		policy.Backoff = restartDelay
+	}
+
+	launcher := ProcessLauncher{
+		config:   config,
+		dir:      dir,
+		children: make(map[string]*child),
+		command: func(configPath string) *exec.Cmd {
+			return exec.Command(executable, "-config", configPath, "node")
+		},
+		stopTimeout: stopTimeout,
+		policy:      policy,
+	}
+
+	return &launcher, nil
+}
+
+// A ProcessLauncher starts nodes as local child processes and restarts
+//   them if they crash, as long as its restart policy allows.
+type ProcessLauncher struct {
+	sync.Mutex
+	config      *Config
+	dir         string
+	count       int
+	children    map[string]*child
+	command     func(configPath string) *exec.Cmd
+	stopTimeout time.Duration
+	policy      RestartPolicy
+	closed      bool
+}
+
+type child struct {
+	node        string
+	configPath  string
+	addressPath string
+	cmd         *exec.Cmd
+	exited      chan struct{}
+	stopping    bool
+	restarts    int
+	started     time.Time
+	delay       time.Duration
+}
+
+// Launch starts a new node and returns its address. The node binds a port
+//   of its own, so no other process can take it first, and reports the
+//   address back through a file.
+func (launcher *ProcessLauncher) Launch() (string, error) {
+	launcher.Lock()
+	if launcher.closed {
+		launcher.Unlock()
+		return "", errors.New("The launcher was closed.")
+	}
+	launcher.count++
+	id := "node-" + strconv.Itoa(launcher.count)
+	launcher.Unlock()
+
+	// The node's ID is its slot, which it keeps when it is restarted.
+	config := *launcher.config
+	config.Node.Port = 0
+	config.NodeID = id
+
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(launcher.dir, id)
+
+	c := &child{
+		configPath:  name + ".json",
+		addressPath: name + ".addr",
+	}
+
+	if err := os.WriteFile(c.configPath, data, 0600); err != nil {
+		return "", err
+	}
+
+	launcher.Lock()
+	err = launcher.start(c)
+	cmd, exited := c.cmd, c.exited
+	launcher.Unlock()
+
+	if err != nil {
+		os.Remove(c.configPath)
+		return "", err
+	}
+
+	node, err := launcher.address(c.addressPath, exited)
+	if err != nil {
+		// The child may have been restarted in the meantime, so it is the
+		//   latest process that gets killed.
+		launcher.Lock()
+		c.stopping = true
+		cmd, exited = c.cmd, c.exited
+		launcher.Unlock()
+
+		cmd.Process.Kill()
+		<-exited
+		launcher.remove(c)
+		return "", err
+	}
+
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	c.node = node
+	launcher.children[node] = c
+
+	return node, nil
+}
+
+// Stop asks a node to drain, so the work it has is finished, and kills it
+//   if it doesn't exit in time.
+func (launcher *ProcessLauncher) Stop(node string) error {
+	launcher.Lock()
+	c, ok := launcher.children[node]
+	if !ok || c.stopping {
+		launcher.Unlock()
+		return errors.New("Not a node this launcher manages: " + node)
+	}
+
+	// Once stopping is set, the child won't be restarted so its process
+	//   stays the same from here on.
+	c.stopping = true
+	cmd, exited := c.cmd, c.exited
+	launcher.Unlock()
+
+	// Ask nicely first, and fall back on a signal if the node can't be
+	//   reached.
+	client, err := DialHTTP(node, launcher.stopTimeout, launcher.config.Client)
+	if err == nil {
+		var reply string
+		err = call(client, NodeDo, "DRAIN", &reply, launcher.stopTimeout)
+		client.Close()
+	}
+
+	if err != nil {
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	// A draining node gets as long as it would give itself, and then as
+	//   long as it takes to shut down.
+	drainTimeout := time.Duration(launcher.config.Master.DrainTimeout) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	timeout := launcher.stopTimeout + drainTimeout +
+		time.Duration(launcher.config.Master.ShutdownTimeout)*time.Second
+
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	launcher.Lock()
+	delete(launcher.children, c.node)
+	launcher.Unlock()
+
+	return launcher.remove(c)
+}
+
+// Nodes returns the address of every node the launcher is running.
+func (launcher *ProcessLauncher) Nodes() []string {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	nodes := make([]string, 0, len(launcher.children))
+	for node := range launcher.children {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// start runs a child's process and watches for it to exit. The lock must
+//   be held by the caller.
+func (launcher *ProcessLauncher) start(c *child) error {
+	// An address left from before the child was restarted is stale.
+	os.Remove(c.addressPath)
+
+	cmd := launcher.command(c.configPath)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, AddressEnv+"="+c.addressPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	c.cmd = cmd
+	c.exited = make(chan struct{})
+	c.started = time.Now()
+	go launcher.watch(c, cmd, c.exited)
+
+	return nil
+}
+
+// address waits for a child to report the address it is listening on, and
+//   gives up if the child exits first.
+func (launcher *ProcessLauncher) address(path string, exited chan struct{}) (string, error) {
+	deadline := time.After(launchTimeout)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && len(data) > 0 {
+			return strings.TrimSpace(string(data)), nil
+		}
+
+		select {
+		case <-exited:
+			return "", errors.New("The node exited before reporting its address.")
+		case <-deadline:
+			return "", errors.New("The node did not report its address in time.")
+		case <-time.After(addressPoll):
+		}
+	}
+}
+
+// drop lets go of a child that won't be restarted. The lock must be held
+//   by the caller.
+func (launcher *ProcessLauncher) drop(c *child) {
+	c.stopping = true
+	delete(launcher.children, c.node)
+	launcher.remove(c)
+}
+
+// Close deletes the directory the nodes' configs are kept in, since they
+//   hold credentials. Nodes can't be launched after, and those still
+//   running should be stopped first, see StopNodes.
+func (launcher *ProcessLauncher) Close() error {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	launcher.closed = true
+	return os.RemoveAll(launcher.dir)
+}
+
+// remove deletes the files a child was started with.
+func (launcher *ProcessLauncher) remove(c *child) error {
+	os.Remove(c.addressPath)
+	return os.Remove(c.configPath)
+}
+
+// watch waits for a child to exit and restarts it unless it was stopped.
+//   A child that exits cleanly was drained or halted, so it is let go, and
+//   so is one that crashed more often than the restart policy allows.
+//   Restarts wait the policy's backoff, doubling each time. A restarted
+//   child binds a new port, so it is listed under its new address once it
+//   reports one.
+func (launcher *ProcessLauncher) watch(c *child, cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+	close(exited)
+
+	launcher.Lock()
+	if c.stopping {
+		launcher.Unlock()
+		return
+	}
+
+	policy := launcher.policy
+	switch {
+	case err == nil:
+		fmt.Println("Node stopped:", c.node)
+		launcher.drop(c)
+		launcher.Unlock()
+		return
+	case policy.Policy != RestartAlways && policy.Policy != RestartOnFailure:
+		fmt.Println("Node exited:", c.node, err)
+		launcher.drop(c)
+		launcher.Unlock()
+		return
+	case policy.MaxRestarts > 0 && c.restarts >= policy.MaxRestarts:
+		fmt.Println("Node gave up after", c.restarts, "restarts:", c.node, err)
+		launcher.drop(c)
+		launcher.Unlock()
+		return
+	}
+
+	// A child that ran for a while before crashing starts its backoff
+	//   over.
+	if c.delay == 0 || (policy.MaxBackoff > 0 && time.Since(c.started) > policy.MaxBackoff) {
+		c.delay = policy.Backoff
+	}
+	delay := c.delay
+
+	c.delay *= 2
+	if policy.MaxBackoff > 0 && c.delay > policy.MaxBackoff {
+		c.delay = policy.MaxBackoff
+	}
+	launcher.Unlock()
+
+	fmt.Println("Node exited, restarting in", delay, "after:", c.node, err)
+	time.Sleep(delay)
+
+	launcher.Lock()
+	if c.stopping {
+		launcher.Unlock()
+		return
+	}
+
+	c.restarts++
+	err = launcher.start(c)
+	if err != nil {
+		fmt.Println("Restarting node failed:", c.node, err)
+		launcher.drop(c)
+		launcher.Unlock()
+		return
+	}
+	path, restarted := c.addressPath, c.exited
+	launcher.Unlock()
+
+	node, err := launcher.address(path, restarted)
+	if err != nil {
+		fmt.Println("Restarted node did not come up:", c.node, err)
+		return
+	}
+
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	if c.stopping || c.exited != restarted {
+		return
+	}
+
+	delete(launcher.children, c.node)
+	c.node = node
+	launcher.children[node] = c
+}
+
+// A LauncherService publishes a Launcher over RPC so an operator can start
+//   and stop nodes through the master.
+type LauncherService struct {
+	launcher Launcher
+}
+
+// NewLauncherService wraps a Launcher to be registered with a ControlServer.
+func NewLauncherService(launcher Launcher) *LauncherService {
+	return &LauncherService{launcher}
+}
+
+// Launch starts a node and replies with its address.
+func (service *LauncherService) Launch(unused bool, node *string) error {
+	launched, err := service.launcher.Launch()
+	*node = launched
+	return err
+}
+
+// Stop stops a node.
+func (service *LauncherService) Stop(node string, reply *bool) error {
+	err := service.launcher.Stop(node)
+	*reply = err == nil
+	return err
+}
+
+// Nodes replies with every node the launcher manages.
+func (service *LauncherService) Nodes(unused bool, nodes *[]string) error {
+	*nodes = service.launcher.Nodes()
+	return nil
+}
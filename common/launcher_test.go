@@ -0,0 +1,175 @@
+package common
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLauncherHelperProcess stands in for a node when the launcher is
+//   tested. It only runs when started by the launcher.
+func TestLauncherHelperProcess(t *testing.T) {
+	if os.Getenv("LBDEMO_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	listener, port, err := Listen("localhost", 0, nil)
+	if err != nil {
+		os.Exit(1)
+	}
+	defer listener.Close()
+
+	if err := ReportAddress("localhost:" + strconv.Itoa(port)); err != nil {
+		os.Exit(1)
+	}
+
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+// newTestLauncher returns a launcher that starts the helper process, and
+//   restarts it under the given policy.
+func newTestLauncher(t *testing.T, restart RestartConfig) *ProcessLauncher {
+	launcher, err := NewProcessLauncher(&Config{Master: MasterConfig{Restart: restart}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		StopNodes(launcher)
+	})
+
+	launcher.stopTimeout = 100 * time.Millisecond
+	launcher.command = func(configPath string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=TestLauncherHelperProcess")
+		cmd.Env = append(os.Environ(), "LBDEMO_HELPER_PROCESS=1")
+		return cmd
+	}
+
+	return launcher
+}
+
+// crash kills a launched node's process, and waits until the launcher has
+//   either restarted it under a new address or let it go.
+func crash(t *testing.T, launcher *ProcessLauncher, node string) []string {
+	launcher.Lock()
+	launcher.children[node].cmd.Process.Kill()
+	launcher.Unlock()
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+
+		nodes := launcher.Nodes()
+		if len(nodes) == 0 || nodes[0] != node {
+			return nodes
+		}
+	}
+
+	t.Fatal("The crashed node was neither restarted nor let go.")
+	return nil
+}
+
+func TestProcessLauncher(t *testing.T) {
+	launcher := newTestLauncher(t, RestartConfig{RestartOnFailure, 0, 10, 0})
+
+	node, err := launcher.Launch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(node, "localhost:") || node == "localhost:0" {
+		t.Error(node, "The node's own address was not reported.")
+	}
+
+	nodes := launcher.Nodes()
+	if len(nodes) != 1 || nodes[0] != node {
+		t.Error(nodes, "Launched node was not listed.")
+	}
+
+	// The node is known by its slot, which it keeps across restarts.
+	launcher.Lock()
+	configPath := launcher.children[node].configPath
+	launcher.Unlock()
+
+	if config, err := LoadConfig(configPath); err != nil || config.NodeID != "node-1" {
+		t.Error(config, err, "The node was not given its slot as its ID.")
+	}
+
+	// Crash the child and make sure it comes back, under the address it
+	//   bound this time.
+	nodes = crash(t, launcher, node)
+	if len(nodes) != 1 {
+		t.Fatal(nodes, "Restarted node was not listed.")
+	}
+	node = nodes[0]
+
+	launcher.Lock()
+	restarts := launcher.children[node].restarts
+	launcher.Unlock()
+
+	if restarts != 1 {
+		t.Error(restarts, "Crashed node was not restarted.")
+	}
+
+	// The helper can't be halted over RPC, so it gets signalled instead.
+	if err := launcher.Stop(node); err != nil {
+		t.Error(err)
+	}
+
+	if len(launcher.Nodes()) != 0 {
+		t.Error("Stopped node was still listed.")
+	}
+
+	if err := launcher.Stop(node); err == nil {
+		t.Error("Stopping an unknown node did not fail.")
+	}
+
+	// Stopping every node deletes the configs, credentials and all.
+	if _, err := launcher.Launch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := StopNodes(launcher); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(launcher.dir); !os.IsNotExist(err) {
+		t.Error(err, "The directory of node configs was left behind.")
+	}
+
+	if _, err := launcher.Launch(); err == nil {
+		t.Error("A closed launcher should not launch nodes.")
+	}
+}
+
+func TestProcessLauncherRestartPolicy(t *testing.T) {
+	// A node only gets so many restarts.
+	launcher := newTestLauncher(t, RestartConfig{RestartOnFailure, 1, 10, 0})
+
+	node, err := launcher.Launch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := crash(t, launcher, node)
+	if len(nodes) != 1 {
+		t.Fatal(nodes, "The first crash should have been restarted.")
+	}
+
+	if nodes = crash(t, launcher, nodes[0]); len(nodes) != 0 {
+		t.Error(nodes, "The second crash should have been given up on.")
+	}
+
+	// Without a policy to restart it, a node is let go.
+	launcher = newTestLauncher(t, RestartConfig{RestartNever, 0, 10, 0})
+
+	node, err = launcher.Launch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nodes = crash(t, launcher, node); len(nodes) != 0 {
+		t.Error(nodes, "A node should not be restarted under the never policy.")
+	}
+}
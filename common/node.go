@@ -0,0 +1,245 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magnesium38/balancer"
+)
+
+// The name a node publishes its worker under, and the method work is handed
+//   to. The api package names them for the other tiers, the launcher needs
+//   them to drain the nodes it started.
+const (
+	NodeService = "Server"
+	NodeDo      = NodeService + ".Do"
+)
+
+// ErrDraining is returned for work sent to a node that is draining.
+var ErrDraining = errors.New("The node is draining and takes no new work.")
+
+// ErrDrained is what a node stops with once it has drained.
+var ErrDrained = errors.New("The node was drained.")
+
+// invalidWorkPrefix marks work a worker turned down as invalid, since the
+//   error reaches the caller as plain text.
+const invalidWorkPrefix = "invalid work: "
+
+// How long a node gets to drain when no timeout is configured.
+const defaultDrainTimeout = 30 * time.Second
+
+// A Drainer is a worker with work of its own to finish before its node
+//   stops, like flushing a queue. Drain is called once the node's in-flight
+//   work is done, and should give up by the deadline.
+type Drainer interface {
+	Drain(deadline time.Time) error
+}
+
+// Listen binds the address a node will serve on. A port of 0 lets the
+//   system pick one, and the port actually bound is returned so it can be
+//   registered. Binding before registering means no other process can take
+//   the port in between. With a TLS config, connections are served over
+//   TLS.
+func Listen(host string, port int, config *tls.Config) (net.Listener, int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	port = listener.Addr().(*net.TCPAddr).Port
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	return listener, port, nil
+}
+
+// NewNode returns a Node that serves worker on a listener that is already
+//   bound. Once told to DRAIN, it gets drainTimeout to finish up. With an
+//   Auth, calls without a valid token are turned away.
+func NewNode(listener net.Listener, worker balancer.Worker, drainTimeout time.Duration, auth *Auth) (*Node, error) {
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+
+	node := &Node{
+		listener:     listener,
+		server:       rpc.NewServer(),
+		worker:       worker,
+		drainTimeout: drainTimeout,
+		drained:      make(chan struct{}),
+		auth:         auth,
+	}
+
+	err := node.server.RegisterName(NodeService, &nodeService{node})
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// A Node accepts work from its load balancer over RPC. The work DRAIN is
+//   handled by the node itself rather than the worker: the node stops taking
+//   work, lets what it already took finish, and then lets the worker drain.
+type Node struct {
+	sync.Mutex
+	listener     net.Listener
+	server       *rpc.Server
+	worker       balancer.Worker
+	drainTimeout time.Duration
+	draining     bool
+	inFlight     sync.WaitGroup
+	drained      chan struct{}
+	auth         *Auth
+}
+
+// ListenAndServe accepts RPC connections until an error occurs. The
+//   listener was bound when the node was created, so this only serves.
+func (node *Node) ListenAndServe() error {
+	return http.Serve(node.listener, node.auth.Handler(node.server))
+}
+
+// Close stops the node from accepting connections, which ends
+//   ListenAndServe.
+func (node *Node) Close() error {
+	return node.listener.Close()
+}
+
+// Drained blocks until the node has drained, and then returns ErrDrained so
+//   the rest of the node's jobs stop too. It returns early once ctx is
+//   cancelled.
+func (node *Node) Drained(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-node.drained:
+		return ErrDrained
+	}
+}
+
+// Stopped returns whether a process stopped because it was told to, by a
+//   DRAIN, a HALT or a signal, rather than because something failed. Such a
+//   process exits cleanly so it isn't restarted.
+func Stopped(err error) bool {
+	return errors.Is(err, ErrDrained) || errors.Is(err, ErrHalted) ||
+		errors.Is(err, ErrSignaled)
+}
+
+// Drain stops the node from taking new work and starts finishing what it
+//   has. It returns right away, Drained says when it is done.
+func (node *Node) Drain() {
+	node.Lock()
+	defer node.Unlock()
+
+	if node.draining {
+		return
+	}
+	node.draining = true
+
+	go node.drain()
+}
+
+// drain waits on the in-flight work and the worker, up to the deadline.
+func (node *Node) drain() {
+	deadline := time.Now().Add(node.drainTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		node.inFlight.Wait()
+
+		if drainer, ok := node.worker.(Drainer); ok {
+			if err := drainer.Drain(deadline); err != nil {
+				fmt.Println("Draining failed:", err)
+			}
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("Drained.")
+	case <-time.After(time.Until(deadline)):
+		fmt.Println("Draining took too long, stopping anyway.")
+	}
+
+	close(node.drained)
+}
+
+// begin counts work as in-flight, unless the node is draining.
+func (node *Node) begin() bool {
+	node.Lock()
+	defer node.Unlock()
+
+	if node.draining {
+		return false
+	}
+
+	node.inFlight.Add(1)
+	return true
+}
+
+// nodeService is what a load balancer calls on a node.
+type nodeService struct {
+	node *Node
+}
+
+// Do hands work to the worker.
+func (service *nodeService) Do(work string, response *string) error {
+	if work == "DRAIN" {
+		service.node.Drain()
+		*response = "DRAINING"
+		return nil
+	}
+
+	if !service.node.begin() {
+		return ErrDraining
+	}
+	defer service.node.inFlight.Done()
+
+	result, err := service.node.worker.Do(work)
+	*response = result
+
+	var invalid *balancer.InvalidWorkError
+	if errors.As(err, &invalid) {
+		return errors.New(invalidWorkPrefix + invalid.Str)
+	}
+
+	return err
+}
+
+// AsInvalidWork finds an InvalidWorkError in err. Errors that crossed RPC
+//   arrive as plain text, so a node's invalid work is made one again.
+func AsInvalidWork(err error) (*balancer.InvalidWorkError, bool) {
+	var invalid *balancer.InvalidWorkError
+	if errors.As(err, &invalid) {
+		return invalid, true
+	}
+
+	if _, ok := err.(rpc.ServerError); !ok {
+		return nil, false
+	}
+
+	text := err.Error()
+	if !strings.HasPrefix(text, invalidWorkPrefix) {
+		return nil, false
+	}
+
+	return &balancer.InvalidWorkError{Str: text[len(invalidWorkPrefix):]}, true
+}
+
+// Status replies with the worker's status in its string form.
+func (service *nodeService) Status(requestTime time.Time, response *string) error {
+	*response = service.node.worker.Status(requestTime).String()
+	return nil
+}
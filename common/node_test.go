@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/balancer"
+)
+
+type echoWorker struct {
+}
+
+func (worker *echoWorker) Do(work string) (string, error) {
+	if work == "" {
+		return "", errors.New("Nothing to do.")
+	}
+
+	if work == "?" {
+		return "", &balancer.InvalidWorkError{Str: "Unknown work."}
+	}
+
+	return work, nil
+}
+
+func (worker *echoWorker) Status(requestTime time.Time) balancer.Status {
+	return NewStatus()
+}
+
+func TestNode(t *testing.T) {
+	listener, port, err := Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if port == 0 {
+		t.Fatal("The bound port was not returned.")
+	}
+
+	// A second node can't be given the same port while the first holds it.
+	if _, _, err := Listen("localhost", port, nil); err == nil {
+		t.Error("The port was bound twice.")
+	}
+
+	node, err := NewNode(listener, &echoWorker{}, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go node.ListenAndServe()
+
+	client, err := rpc.DialHTTP("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var response string
+	if err := client.Call("Server.Do", "JOIN #channel", &response); err != nil {
+		t.Error(err)
+	}
+	if response != "JOIN #channel" {
+		t.Error(response, "The work was not handed to the worker.")
+	}
+
+	if err := client.Call("Server.Do", "", &response); err == nil {
+		t.Error("The worker's error was not returned.")
+	}
+
+	// Invalid work can be told from other errors once it crosses RPC.
+	err = client.Call("Server.Do", "", &response)
+	if _, ok := AsInvalidWork(err); ok {
+		t.Error(err, "A failure was taken for invalid work.")
+	}
+
+	err = client.Call("Server.Do", "?", &response)
+	if invalid, ok := AsInvalidWork(err); !ok || invalid.Str != "Unknown work." {
+		t.Error(err, "Invalid work was not found in the error.")
+	}
+
+	if err := client.Call("Server.Status", time.Now(), &response); err != nil {
+		t.Error(err)
+	}
+}
+
+// slowWorker takes a while with its work, and notes whether that work was
+//   done by the time it was drained.
+type slowWorker struct {
+	echoWorker
+	finished chan struct{}
+	drained  chan bool
+}
+
+func (worker *slowWorker) Do(work string) (string, error) {
+	time.Sleep(200 * time.Millisecond)
+	close(worker.finished)
+	return work, nil
+}
+
+func (worker *slowWorker) Drain(deadline time.Time) error {
+	select {
+	case <-worker.finished:
+		worker.drained <- true
+	default:
+		worker.drained <- false
+	}
+	return nil
+}
+
+func TestNodeDrain(t *testing.T) {
+	listener, port, err := Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	worker := &slowWorker{
+		finished: make(chan struct{}),
+		drained:  make(chan bool, 1),
+	}
+	node, err := NewNode(listener, worker, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	client, err := rpc.DialHTTP("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Start some work, and drain while it is still in flight.
+	var slow string
+	call := client.Go("Server.Do", "JOIN #channel", &slow, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	var response string
+	if err := client.Call("Server.Do", "DRAIN", &response); err != nil || response != "DRAINING" {
+		t.Error(response, err, "The node did not start draining.")
+	}
+
+	if err := client.Call("Server.Do", "PART #channel", &response); err == nil {
+		t.Error("A draining node took new work.")
+	}
+
+	drained := make(chan error)
+	go func() { drained <- node.Drained(context.Background()) }()
+
+	select {
+	case err := <-drained:
+		if !Stopped(err) {
+			t.Error(err, "A drained node should stop cleanly.")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The node never drained.")
+	}
+
+	if !<-worker.drained {
+		t.Error("The worker was drained before its work was done.")
+	}
+
+	<-call.Done
+	if call.Error != nil || slow != "JOIN #channel" {
+		t.Error(slow, call.Error, "The work in flight did not finish.")
+	}
+}
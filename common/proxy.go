@@ -0,0 +1,200 @@
+package common
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dialProxy connects to addr through the proxy at proxyURL, or straight to
+//   it when there is no proxy. A proxy is either socks5:// or http://, and
+//   its credentials, if it needs any, go in the URL. Reaching the proxy or
+//   addr gives up after timeout.
+func dialProxy(proxyURL string, addr string, timeout time.Duration) (net.Conn, error) {
+	if proxyURL == "" {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var dial func(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error)
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		dial = dialSOCKS5
+	case "http":
+		dial = dialHTTPConnect
+	default:
+		return nil, errors.New("Unknown proxy type: " + proxy.Scheme)
+	}
+
+	deadline := time.Now().Add(timeout)
+	conn, err := net.DialTimeout("tcp", proxy.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The proxy has what is left of the timeout to reach addr.
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tunnel, err := dial(conn, proxy, addr)
+	if err == nil {
+		err = tunnel.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tunnel, nil
+}
+
+// The parts of SOCKS5 that are used.
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksPasswordAuth = 0x02
+	socksNoMethod     = 0xFF
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+)
+
+// dialSOCKS5 asks a SOCKS5 proxy to connect to addr. The proxy is the one
+//   that looks up the host.
+func dialSOCKS5(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, err
+	}
+	if len(host) > 255 {
+		return nil, errors.New("The host is too long for SOCKS5: " + host)
+	}
+
+	// Offer a username and password only when there are some.
+	methods := []byte{socksNoAuth}
+	if proxy.User != nil {
+		methods = append(methods, socksPasswordAuth)
+	}
+	conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...))
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != socksVersion {
+		return nil, errors.New("The proxy does not speak SOCKS5.")
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPasswordAuth:
+		if proxy.User == nil {
+			return nil, errors.New("The SOCKS5 proxy wants a username and password.")
+		}
+
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		request := []byte{0x01, byte(len(username))}
+		request = append(request, username...)
+		request = append(request, byte(len(password)))
+		request = append(request, password...)
+		conn.Write(request)
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+		if reply[1] != 0x00 {
+			return nil, errors.New("The SOCKS5 proxy turned down the username and password.")
+		}
+	default:
+		return nil, errors.New("The SOCKS5 proxy accepts none of the offered auth methods.")
+	}
+
+	request := []byte{socksVersion, socksConnect, 0x00, socksDomain, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	conn.Write(request)
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[1] != 0x00 {
+		return nil, errors.New("The SOCKS5 proxy could not connect, code " + strconv.Itoa(int(header[1])) + ": " + addr)
+	}
+
+	// Skip over the address the proxy bound, and its port.
+	var bound int
+	switch header[3] {
+	case socksIPv4:
+		bound = net.IPv4len
+	case socksIPv6:
+		bound = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		bound = int(length[0])
+	default:
+		return nil, errors.New("The SOCKS5 proxy replied with an unknown address type.")
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, bound+2)); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dialHTTPConnect asks an HTTP proxy to open a tunnel to addr.
+func dialHTTPConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	request := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	io.WriteString(conn, request+"\r\n")
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("The HTTP proxy could not connect: " + response.Status)
+	}
+
+	// Anything the other end already sent is in the reader.
+	return &bufferedConn{conn, reader}, nil
+}
+
+// A bufferedConn is a connection that some of was already read into a
+//   buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
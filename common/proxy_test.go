@@ -0,0 +1,185 @@
+package common
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// serveSOCKS5 is a SOCKS5 proxy that wants the given username and password,
+//   and connects clients to wherever they ask.
+func serveSOCKS5(listener net.Listener, username string, password string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			greeting := make([]byte, 2)
+			if _, err := io.ReadFull(conn, greeting); err != nil {
+				return
+			}
+			methods := make([]byte, greeting[1])
+			if _, err := io.ReadFull(conn, methods); err != nil {
+				return
+			}
+			conn.Write([]byte{socksVersion, socksPasswordAuth})
+
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			user := make([]byte, header[1])
+			io.ReadFull(conn, user)
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			pass := make([]byte, length[0])
+			io.ReadFull(conn, pass)
+
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+
+			request := make([]byte, 5)
+			if _, err := io.ReadFull(conn, request); err != nil || request[3] != socksDomain {
+				return
+			}
+			host := make([]byte, request[4])
+			io.ReadFull(conn, host)
+			port := make([]byte, 2)
+			io.ReadFull(conn, port)
+
+			addr := net.JoinHostPort(string(host), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+			target, err := net.Dial("tcp", addr)
+			if err != nil {
+				conn.Write([]byte{socksVersion, 0x05, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer target.Close()
+
+			conn.Write([]byte{socksVersion, 0x00, 0x00, socksIPv4, 127, 0, 0, 1, 0, 0})
+			pipe(conn, target)
+		}(conn)
+	}
+}
+
+// serveHTTPConnect is an HTTP proxy that wants the given credentials, and
+//   opens tunnels to wherever clients ask.
+func serveHTTPConnect(listener net.Listener, username string, password string) {
+	credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			reader := bufio.NewReader(conn)
+			request, err := http.ReadRequest(reader)
+			if err != nil || request.Method != http.MethodConnect {
+				return
+			}
+
+			if request.Header.Get("Proxy-Authorization") != credentials {
+				io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+				return
+			}
+
+			target, err := net.Dial("tcp", request.Host)
+			if err != nil {
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer target.Close()
+
+			io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			pipe(&bufferedConn{conn, reader}, target)
+		}(conn)
+	}
+}
+
+// pipe copies between two connections until either is done.
+func pipe(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func TestDialIRCProxy(t *testing.T) {
+	irc, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer irc.Close()
+	go serveLines(irc)
+
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	go serveSOCKS5(socks, "user", "secret")
+
+	connect, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+	go serveHTTPConnect(connect, "user", "secret")
+
+	proxies := map[string]string{
+		"SOCKS5":       "socks5://user:secret@" + socks.Addr().String(),
+		"HTTP CONNECT": "http://user:secret@" + connect.Addr().String(),
+	}
+
+	for name, proxy := range proxies {
+		conn, err := DialIRC("irc://"+irc.Addr().String(), proxy, nil, 5*time.Second)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		checkEcho(t, conn)
+
+		// Every connection goes through the proxy, not just the first.
+		conn, err = DialIRC("irc://"+irc.Addr().String(), proxy, nil, 5*time.Second)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		checkEcho(t, conn)
+	}
+
+	wrong := map[string]string{
+		"SOCKS5":       "socks5://user:wrong@" + socks.Addr().String(),
+		"HTTP CONNECT": "http://user:wrong@" + connect.Addr().String(),
+	}
+
+	for name, proxy := range wrong {
+		if _, err := DialIRC("irc://"+irc.Addr().String(), proxy, nil, 5*time.Second); err == nil {
+			t.Error(name, "A wrong password should be refused by the proxy.")
+		}
+	}
+
+	if _, err := DialIRC("irc://"+irc.Addr().String(), "ftp://"+socks.Addr().String(), nil, 5*time.Second); err == nil {
+		t.Error("An unknown proxy type should be refused.")
+	}
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// A RateLimiter allows up to a limit of events within a sliding window.
+type RateLimiter struct {
+	sync.Mutex
+	limit  int
+	window time.Duration
+	events []time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing limit events per window.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+	}
+}
+
+// SetLimit changes the limit while keeping the events already in the window.
+func (limiter *RateLimiter) SetLimit(limit int) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	limiter.limit = limit
+}
+
+// Limit returns the number of events allowed per window.
+func (limiter *RateLimiter) Limit() int {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	return limiter.limit
+}
+
+// Count returns how many events are currently within the window.
+func (limiter *RateLimiter) Count() int {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	limiter.prune(time.Now())
+	return len(limiter.events)
+}
+
+// Wait returns how long until n events would be allowed. A return of
+//   zero means they are allowed now. If n can never fit within the limit,
+//   the full window is returned so callers back off and try again.
+func (limiter *RateLimiter) Wait(n int) time.Duration {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	return limiter.wait(n, time.Now())
+}
+
+// Take records n events if they are allowed, otherwise it returns how long
+//   until they would be.
+func (limiter *RateLimiter) Take(n int) time.Duration {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	now := time.Now()
+	wait := limiter.wait(n, now)
+	if wait > 0 {
+		return wait
+	}
+
+	for i := 0; i < n; i++ {
+		limiter.events = append(limiter.events, now)
+	}
+
+	return 0
+}
+
+func (limiter *RateLimiter) wait(n int, now time.Time) time.Duration {
+	limiter.prune(now)
+
+	if n > limiter.limit {
+		return limiter.window
+	}
+
+	over := len(limiter.events) + n - limiter.limit
+	if over <= 0 {
+		return 0
+	}
+
+	// The oldest events have to leave the window before there is room.
+	return limiter.events[over-1].Add(limiter.window).Sub(now)
+}
+
+func (limiter *RateLimiter) prune(now time.Time) {
+	cutoff := now.Add(-limiter.window)
+
+	i := 0
+	for i < len(limiter.events) && !limiter.events[i].After(cutoff) {
+		i++
+	}
+
+	limiter.events = limiter.events[i:]
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(3, 200*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if wait := limiter.Take(1); wait != 0 {
+			t.Error("Take", i, "was not allowed:", wait)
+		}
+	}
+
+	if limiter.Count() != 3 {
+		t.Error("Count was not three after three takes.")
+	}
+
+	if wait := limiter.Take(1); wait <= 0 {
+		t.Error("Take over the limit was allowed.")
+	}
+
+	if wait := limiter.Wait(4); wait != 200*time.Millisecond {
+		t.Error("Wait for more than the limit was not the window:", wait)
+	}
+
+	time.Sleep(limiter.Wait(1))
+
+	if wait := limiter.Take(1); wait != 0 {
+		t.Error("Take after waiting was not allowed:", wait)
+	}
+
+	limiter.SetLimit(1)
+	if wait := limiter.Take(1); wait <= 0 {
+		t.Error("Take was allowed after lowering the limit.")
+	}
+}
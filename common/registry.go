@@ -0,0 +1,338 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Registry is where nodes announce themselves so their master can send
+//   them work. Nodes are named by the address they listen on, and have to
+//   heartbeat or they expire.
+type Registry interface {
+	Register(node string) error
+	Heartbeat(node string) error
+	Deregister(node string) error
+	Nodes() ([]string, error)
+}
+
+// Heartbeat registers a node and keeps it registered until ctx is
+//   cancelled. A node that was expired in the meantime is registered again.
+func Heartbeat(ctx context.Context, registry Registry, node string, ttl time.Duration) error {
+	if err := registry.Register(node); err != nil {
+		return err
+	}
+
+	// Without a TTL, entries don't expire, but heartbeat anyway so a
+	//   restarted master still finds the node.
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		if err := registry.Heartbeat(node); err != nil {
+			fmt.Println("Heartbeat failed, registering again:", err)
+			if err := registry.Register(node); err != nil {
+				fmt.Println("Registering failed:", err)
+			}
+		}
+	}
+}
+
+// How long to wait on the lock of a registry file before assuming whoever
+//   holds it has died, and how often to try for it in the meantime.
+const (
+	registryLockTimeout = 5 * time.Second
+	registryLockRetry   = 10 * time.Millisecond
+)
+
+// NewFileRegistry returns a Registry kept in files, for nodes that share a
+//   filesystem with their master. A ttl of zero means entries never expire.
+func NewFileRegistry(path string, ttl time.Duration) *FileRegistry {
+	return &FileRegistry{
+		path: path,
+		ttl:  ttl,
+	}
+}
+
+// A FileRegistry keeps the registry in two files. The registry file itself
+//   lists one live node per line, which is what the load balancer reads, and
+//   a leases file next to it records when every entry expires. Both are only
+//   changed while holding a lock file, so several processes can share them.
+type FileRegistry struct {
+	sync.Mutex
+	path string
+	ttl  time.Duration
+}
+
+// Register adds a node, or renews it if it is already there.
+func (registry *FileRegistry) Register(node string) error {
+	return registry.update(func(leases map[string]time.Time) error {
+		leases[node] = registry.expiry()
+		return nil
+	})
+}
+
+// Heartbeat renews a node. A node that already expired has to register
+//   again.
+func (registry *FileRegistry) Heartbeat(node string) error {
+	return registry.update(func(leases map[string]time.Time) error {
+		if _, ok := leases[node]; !ok {
+			return errors.New("Not a registered node: " + node)
+		}
+
+		leases[node] = registry.expiry()
+		return nil
+	})
+}
+
+// Deregister removes a node.
+func (registry *FileRegistry) Deregister(node string) error {
+	return registry.update(func(leases map[string]time.Time) error {
+		delete(leases, node)
+		return nil
+	})
+}
+
+// Nodes returns every node that hasn't expired.
+func (registry *FileRegistry) Nodes() ([]string, error) {
+	var nodes []string
+	err := registry.update(func(leases map[string]time.Time) error {
+		for node := range leases {
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+
+	sort.Strings(nodes)
+	return nodes, err
+}
+
+// Expire removes stale entries twice every ttl, even when no node is around
+//   to touch the registry, until ctx is cancelled.
+func (registry *FileRegistry) Expire(ctx context.Context) error {
+	frequency := registry.ttl / 2
+	if frequency <= 0 {
+		frequency = time.Minute
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(frequency):
+		}
+
+		if err := registry.update(func(map[string]time.Time) error { return nil }); err != nil {
+			fmt.Println("Expiring registry entries failed:", err)
+		}
+	}
+}
+
+// expiry returns when an entry made now will expire. The zero time means
+//   never.
+func (registry *FileRegistry) expiry() time.Time {
+	if registry.ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(registry.ttl)
+}
+
+// update applies change to the leases under the lock, dropping anything
+//   expired, and then writes both files back.
+func (registry *FileRegistry) update(change func(map[string]time.Time) error) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	unlock, err := lockFile(registry.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	leases, err := registry.read()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for node, expires := range leases {
+		if !expires.IsZero() && now.After(expires) {
+			fmt.Println("Registry entry expired:", node)
+			delete(leases, node)
+		}
+	}
+
+	changeErr := change(leases)
+
+	if err := registry.write(leases); err != nil {
+		return err
+	}
+
+	return changeErr
+}
+
+// read loads the leases. Nodes in the registry file without a lease, say
+//   from before there were leases, are given one so they expire unless they
+//   heartbeat.
+func (registry *FileRegistry) read() (map[string]time.Time, error) {
+	leases := make(map[string]time.Time)
+
+	data, err := os.ReadFile(registry.path + ".leases")
+	if err == nil {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(registry.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		node := strings.TrimSpace(line)
+		if node == "" {
+			continue
+		}
+
+		if _, ok := leases[node]; !ok {
+			leases[node] = registry.expiry()
+		}
+	}
+
+	return leases, nil
+}
+
+// write saves the leases and the list of nodes. Each file is replaced in
+//   one go so a reader never sees half of it.
+func (registry *FileRegistry) write(leases map[string]time.Time) error {
+	data, err := json.MarshalIndent(leases, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(registry.path+".leases", data); err != nil {
+		return err
+	}
+
+	nodes := make([]string, 0, len(leases))
+	for node := range leases {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	list := ""
+	for _, node := range nodes {
+		list += node + "\n"
+	}
+
+	return replaceFile(registry.path, []byte(list))
+}
+
+// replaceFile writes data to a temporary file and renames it over path.
+func replaceFile(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// lockFile takes a lock by creating path, which fails while someone else
+//   holds it. A lock older than the timeout is taken to be left over from a
+//   process that died, and is broken.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(registryLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > registryLockTimeout {
+			fmt.Println("Breaking a stale registry lock:", path)
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("Timed out waiting for the registry lock: " + path)
+		}
+
+		time.Sleep(registryLockRetry)
+	}
+}
+
+// A RegistryService publishes a Registry over RPC so nodes can register
+//   with their master directly.
+type RegistryService struct {
+	registry Registry
+}
+
+// NewRegistryService wraps a Registry to be registered with a ControlServer.
+func NewRegistryService(registry Registry) *RegistryService {
+	return &RegistryService{registry}
+}
+
+// Register adds a node.
+func (service *RegistryService) Register(node string, reply *bool) error {
+	err := service.registry.Register(node)
+	*reply = err == nil
+	return err
+}
+
+// Heartbeat renews a node.
+func (service *RegistryService) Heartbeat(node string, reply *bool) error {
+	err := service.registry.Heartbeat(node)
+	*reply = err == nil
+	return err
+}
+
+// Deregister removes a node.
+func (service *RegistryService) Deregister(node string, reply *bool) error {
+	err := service.registry.Deregister(node)
+	*reply = err == nil
+	return err
+}
+
+// Nodes replies with every node that hasn't expired.
+func (service *RegistryService) Nodes(unused bool, nodes *[]string) error {
+	registered, err := service.registry.Nodes()
+	*nodes = registered
+	return err
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbdemo-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nodes.txt")
+
+	// A node from before there were leases should be picked up.
+	if err := os.WriteFile(path, []byte("localhost:9000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewFileRegistry(path, 200*time.Millisecond)
+	if err := registry.Register("localhost:9001"); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := registry.Nodes()
+	if err != nil || len(nodes) != 2 {
+		t.Error(nodes, err, "Both nodes should be registered.")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "localhost:9000\nlocalhost:9001\n" {
+		t.Error(string(data), err, "The registry file should list both nodes.")
+	}
+
+	// Only the node that heartbeats should survive the ttl.
+	time.Sleep(120 * time.Millisecond)
+	if err := registry.Heartbeat("localhost:9001"); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	nodes, err = registry.Nodes()
+	if err != nil || len(nodes) != 1 || nodes[0] != "localhost:9001" {
+		t.Error(nodes, err, "The silent node should have expired.")
+	}
+
+	if err := registry.Heartbeat("localhost:9000"); err == nil {
+		t.Error("An expired node should have to register again.")
+	}
+
+	if err := registry.Deregister("localhost:9001"); err != nil {
+		t.Error(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil || len(data) != 0 {
+		t.Error(string(data), err, "The registry file should be empty.")
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The decisions a Hysteresis can make.
+const (
+	ScaleDown = -1
+	ScaleNone = 0
+	ScaleUp   = 1
+)
+
+// NewAutoscaler returns what autoscaling every tier has in common. The
+//   tier is what its nodes are called in the log.
+func NewAutoscaler(scaling ScalingConfig, launcher Launcher, tier string) *Autoscaler {
+	return &Autoscaler{
+		scaling,
+		launcher,
+		NewHysteresis(scaling.Checks, time.Duration(scaling.Cooldown)*time.Second),
+		tier,
+	}
+}
+
+// An Autoscaler checks on a tier at a fixed frequency and keeps its number
+//   of nodes within the bounds. How busy the tier is, and which node to
+//   remove, is up to the tier.
+type Autoscaler struct {
+	scaling    ScalingConfig
+	launcher   Launcher
+	hysteresis *Hysteresis
+	tier       string
+}
+
+// Run calls check at the configured frequency until ctx is cancelled. A
+//   check that fails is tried again the next time.
+func (autoscaler *Autoscaler) Run(ctx context.Context, check func() error) error {
+	frequency := time.Duration(autoscaler.scaling.CheckFrequency) * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(frequency):
+		}
+
+		if err := check(); err != nil {
+			fmt.Println("Autoscaling failed:", err)
+		}
+	}
+}
+
+// Decide takes how many nodes there are and whether they are over or under
+//   their targets, and returns whether to add or remove one.
+func (autoscaler *Autoscaler) Decide(nodes int, over bool, under bool) int {
+	// Staying within the bounds doesn't need to wait on the hysteresis.
+	if nodes < autoscaler.scaling.MinNodes {
+		return ScaleUp
+	}
+
+	switch autoscaler.hysteresis.Decide(over, under) {
+	case ScaleUp:
+		if nodes < autoscaler.scaling.MaxNodes {
+			return ScaleUp
+		}
+	case ScaleDown:
+		if nodes > autoscaler.scaling.MinNodes {
+			return ScaleDown
+		}
+	}
+
+	return ScaleNone
+}
+
+// Launch starts another node.
+func (autoscaler *Autoscaler) Launch() error {
+	node, err := autoscaler.launcher.Launch()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Launched a", autoscaler.tier, "node:", node)
+	return nil
+}
+
+// Launcher returns the launcher the tier's nodes are started with.
+func (autoscaler *Autoscaler) Launcher() Launcher {
+	return autoscaler.launcher
+}
+
+// NewHysteresis returns a Hysteresis that waits for checks readings in a
+//   row before acting, and for cooldown after each change.
+func NewHysteresis(checks int, cooldown time.Duration) *Hysteresis {
+	return &Hysteresis{
+		checks:   checks,
+		cooldown: cooldown,
+	}
+}
+
+// A Hysteresis keeps autoscaling from flapping. A single busy or quiet
+//   reading isn't enough to add or remove a node, it takes several in a row
+//   and there is a cooldown after every change.
+type Hysteresis struct {
+	checks     int
+	cooldown   time.Duration
+	over       int
+	under      int
+	lastChange time.Time
+}
+
+// Decide takes the latest reading and returns whether to scale.
+func (hysteresis *Hysteresis) Decide(over bool, under bool) int {
+	switch {
+	case over:
+		hysteresis.over++
+		hysteresis.under = 0
+	case under:
+		hysteresis.under++
+		hysteresis.over = 0
+	default:
+		hysteresis.over = 0
+		hysteresis.under = 0
+	}
+
+	if time.Since(hysteresis.lastChange) < hysteresis.cooldown {
+		return ScaleNone
+	}
+
+	decision := ScaleNone
+	if hysteresis.over >= hysteresis.checks {
+		decision = ScaleUp
+	} else if hysteresis.under >= hysteresis.checks {
+		decision = ScaleDown
+	}
+
+	if decision != ScaleNone {
+		hysteresis.over = 0
+		hysteresis.under = 0
+		hysteresis.lastChange = time.Now()
+	}
+
+	return decision
+}
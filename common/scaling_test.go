@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHysteresis(t *testing.T) {
+	hysteresis := NewHysteresis(3, 100*time.Millisecond)
+
+	// Readings have to be in a row to count.
+	readings := []bool{true, true, false, true, true}
+	for _, over := range readings {
+		if hysteresis.Decide(over, false) != ScaleNone {
+			t.Error("Scaled before enough readings in a row.")
+		}
+	}
+
+	if hysteresis.Decide(true, false) != ScaleUp {
+		t.Error("Did not scale up after three readings in a row.")
+	}
+
+	// The cooldown holds off the next change.
+	for i := 0; i < 3; i++ {
+		if hysteresis.Decide(false, true) != ScaleNone {
+			t.Error("Scaled during the cooldown.")
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if hysteresis.Decide(false, true) != ScaleDown {
+		t.Error("Did not scale down once the cooldown was over.")
+	}
+}
+
+func TestAutoscalerDecide(t *testing.T) {
+	scaling := ScalingConfig{MinNodes: 1, MaxNodes: 3, Checks: 1}
+	autoscaler := NewAutoscaler(scaling, nil, "test")
+
+	tests := []struct {
+		nodes    int
+		over     bool
+		under    bool
+		decision int
+	}{
+		// Below the minimum, a node is added whatever the readings.
+		{0, false, true, ScaleUp},
+		{1, true, false, ScaleUp},
+		{3, true, false, ScaleNone},
+		{2, false, true, ScaleDown},
+		{1, false, true, ScaleNone},
+		{2, false, false, ScaleNone},
+	}
+
+	for _, test := range tests {
+		decision := autoscaler.Decide(test.nodes, test.over, test.under)
+		if decision != test.decision {
+			t.Error(test, decision, "The wrong decision was made.")
+		}
+	}
+}
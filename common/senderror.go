@@ -0,0 +1,69 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// The msg-id values Twitch uses in a NOTICE when it rejects a message. Any
+//   msg-id starting with "msg_" is a rejection, these are just the common
+//   ones callers are likely to check for.
+const (
+	ReasonDuplicate        = "msg_duplicate"
+	ReasonSlowMode         = "msg_slowmode"
+	ReasonBanned           = "msg_banned"
+	ReasonRateLimit        = "msg_ratelimit"
+	ReasonChannelSuspended = "msg_channel_suspended"
+	ReasonFollowersOnly    = "msg_followersonly"
+	ReasonEmoteOnly        = "msg_emoteonly"
+)
+
+const sendErrorPrefix = "send rejected ("
+
+// A SendError is returned by a writer when Twitch rejects a message.
+type SendError struct {
+	Reason  string
+	Channel string
+	Notice  string
+}
+
+// IsRejection reports whether a NOTICE msg-id means a message was rejected.
+func IsRejection(reason string) bool {
+	return strings.HasPrefix(reason, "msg_")
+}
+
+func (err *SendError) Error() string {
+	return sendErrorPrefix + err.Reason + ") in " + err.Channel + ": " + err.Notice
+}
+
+// AsSendError finds a SendError in err. Errors that crossed RPC arrive as
+//   plain text, so those are parsed back into a SendError.
+func AsSendError(err error) (*SendError, bool) {
+	if err == nil {
+		return nil, false
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr, true
+	}
+
+	text := err.Error()
+	if !strings.HasPrefix(text, sendErrorPrefix) {
+		return nil, false
+	}
+	text = text[len(sendErrorPrefix):]
+
+	end := strings.Index(text, ") in ")
+	if end < 0 {
+		return nil, false
+	}
+	reason, text := text[:end], text[end+len(") in "):]
+
+	channel, notice := text, ""
+	if i := strings.Index(text, ": "); i >= 0 {
+		channel, notice = text[:i], text[i+2:]
+	}
+
+	return &SendError{reason, channel, notice}, true
+}
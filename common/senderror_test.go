@@ -0,0 +1,38 @@
+package common
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+)
+
+func TestAsSendError(t *testing.T) {
+	original := &SendError{
+		ReasonSlowMode,
+		"#channel",
+		"This room is in slow mode: you may send again in 2 seconds.",
+	}
+
+	found, ok := AsSendError(original)
+	if !ok || found != original {
+		t.Error("SendError was not found as itself.")
+	}
+
+	// This is how the error looks after it comes back through net/rpc.
+	found, ok = AsSendError(rpc.ServerError(original.Error()))
+	if !ok {
+		t.Fatal("SendError was not parsed from its text.")
+	}
+
+	if *found != *original {
+		t.Error(found, "Parsed SendError did not match the original.")
+	}
+
+	if _, ok := AsSendError(errors.New("connection reset")); ok {
+		t.Error("Unrelated error was taken as a SendError.")
+	}
+
+	if !IsRejection(ReasonDuplicate) || IsRejection("host_on") {
+		t.Error("Rejections were not told apart from other notices.")
+	}
+}
@@ -0,0 +1,195 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A SpoolEntry is one record in a Spool. An entry is first added with its
+//   work, then added again with Done set once the work is finished.
+type SpoolEntry struct {
+	ID       string    `json:"id"`
+	Key      string    `json:"key,omitempty"`
+	Work     string    `json:"work,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Done     bool      `json:"done,omitempty"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// A Spool is an append-only file of work that was accepted but may not
+//   have been finished yet, so it can survive a crash or restart.
+type Spool struct {
+	sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	count   int
+}
+
+// OpenSpool opens the spool at path, creating it if needed. Work that was
+//   added but never finished is returned so it can be replayed, unless it
+//   has expired. Work finished within the last keep duration is returned
+//   as well so its keys are still known. The file is rewritten to hold only
+//   what is returned, which keeps it from growing forever.
+func OpenSpool(path string, keep time.Duration) (*Spool, []SpoolEntry, error) {
+	entries, err := readSpool(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries = keepEntries(entries, keep)
+
+	// Write what is kept to a new file, then swap it in.
+	temp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encoder := json.NewEncoder(temp)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			temp.Close()
+			return nil, nil, err
+		}
+	}
+
+	if err := temp.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spool := Spool{
+		path:    path,
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}
+
+	return &spool, entries, nil
+}
+
+// readSpool reads every entry in a spool file, merging the finished record
+//   of an entry into the one that added it.
+func readSpool(path string) ([]SpoolEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []SpoolEntry
+	index := make(map[string]int)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry SpoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash in the middle of a write leaves a partial last line.
+			continue
+		}
+
+		if i, ok := index[entry.ID]; ok {
+			if entry.Done {
+				entry.Work = entries[i].Work
+				entry.Expires = entries[i].Expires
+				entries[i] = entry
+			}
+			continue
+		}
+
+		index[entry.ID] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// keepEntries drops expired work and work finished too long ago.
+func keepEntries(entries []SpoolEntry, keep time.Duration) []SpoolEntry {
+	now := time.Now()
+	var kept []SpoolEntry
+
+	for _, entry := range entries {
+		if entry.Done {
+			if entry.Key != "" && now.Sub(entry.Finished) < keep {
+				kept = append(kept, entry)
+			}
+			continue
+		}
+
+		if entry.Expires.IsZero() || now.Before(entry.Expires) {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// Add records work that was accepted and returns the entry's id.
+func (spool *Spool) Add(entry SpoolEntry) (string, error) {
+	spool.Lock()
+	defer spool.Unlock()
+
+	spool.count++
+	entry.ID = strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(spool.count)
+	entry.Done = false
+
+	if err := spool.write(entry); err != nil {
+		return "", err
+	}
+
+	return entry.ID, nil
+}
+
+// Finish records that the work of an entry is done, and what came of it.
+func (spool *Spool) Finish(id string, key string, response string, err error) error {
+	spool.Lock()
+	defer spool.Unlock()
+
+	entry := SpoolEntry{
+		ID:       id,
+		Key:      key,
+		Done:     true,
+		Response: response,
+		Finished: time.Now(),
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return spool.write(entry)
+}
+
+// Close closes the spool's file.
+func (spool *Spool) Close() error {
+	spool.Lock()
+	defer spool.Unlock()
+
+	return spool.file.Close()
+}
+
+// write appends an entry and makes sure it reached the disk.
+func (spool *Spool) write(entry SpoolEntry) error {
+	if err := spool.encoder.Encode(entry); err != nil {
+		return err
+	}
+
+	return spool.file.Sync()
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := os.MkdirTemp("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "writer.spool")
+
+	spool, entries, err := OpenSpool(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Error(entries, "New spool was not empty.")
+	}
+
+	finished, _ := spool.Add(SpoolEntry{Key: "a", Work: "SAY #channel one"})
+	spool.Add(SpoolEntry{Work: "SAY #channel two"})
+	spool.Add(SpoolEntry{
+		Work:    "SAY #channel expired",
+		Expires: time.Now().Add(-time.Second),
+	})
+	spool.Finish(finished, "a", "", errors.New("failed"))
+	spool.Close()
+
+	spool, entries, err = OpenSpool(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatal(entries, "Reopened spool did not have two entries.")
+	}
+
+	if !entries[0].Done || entries[0].Key != "a" || entries[0].Error != "failed" {
+		t.Error(entries[0], "Finished entry was not kept with its result.")
+	}
+
+	if entries[1].Done || entries[1].Work != "SAY #channel two" {
+		t.Error(entries[1], "Unfinished entry was not kept for replay.")
+	}
+
+	// Finished entries are only kept as long as asked.
+	spool.Close()
+	spool, entries, err = OpenSpool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Done {
+		t.Error(entries, "Finished entry was kept past its time.")
+	}
+
+	spool.Close()
+}
+
+func TestSpoolCompacts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "writer.spool")
+
+	spool, _, err := OpenSpool(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		id, _ := spool.Add(SpoolEntry{Key: "key", Work: "SAY #channel finished"})
+		spool.Finish(id, "key", "", nil)
+		spool.Add(SpoolEntry{Work: "SAY #channel expired", Expires: time.Now().Add(-time.Second)})
+	}
+	spool.Add(SpoolEntry{Work: "SAY #channel pending"})
+	spool.Close()
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing finished is kept, so only the pending entry should be left.
+	spool, entries, err := OpenSpool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Close()
+
+	if len(entries) != 1 || entries[0].Work != "SAY #channel pending" {
+		t.Error(entries, "Only the pending entry should have been kept.")
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size()/10 {
+		t.Error(before.Size(), after.Size(), "The spool file did not shrink.")
+	}
+
+	// What was dropped stays dropped.
+	spool, entries, err = OpenSpool(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Close()
+
+	if len(entries) != 1 {
+		t.Error(entries, "The compacted spool did not read back the same.")
+	}
+}
@@ -1,9 +1,15 @@
 package common
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
-// Status s
+// A Status is what a node without a status of its own reports, which is
+//   how its jobs are doing. App nodes report it, and an operator reads it
+//   through the master proxy's Status.
 type Status struct {
+	Jobs []JobStats `json:"jobs"`
 }
 
 // NewStatus builds and returns a new status.
@@ -16,12 +22,17 @@ func (status *Status) GetIdleTime() time.Duration {
 	return time.Minute
 }
 
-// String s
+// String encodes the status.
 func (status *Status) String() string {
-	return time.Now().String()
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
 }
 
-// Update s
+// Update decodes a status that was encoded with String.
 func (status *Status) Update(z string) {
-
+	json.Unmarshal([]byte(z), status)
 }
@@ -0,0 +1,43 @@
+package common
+
+import "strings"
+
+var tagUnescaper = strings.NewReplacer(
+	`\:`, ";",
+	`\s`, " ",
+	`\\`, `\`,
+	`\r`, "\r",
+	`\n`, "\n",
+)
+
+// ParseTags splits the IRCv3 tags off the front of a line, which Twitch
+//   uses to attach extra data to messages. A line without tags returns an
+//   empty map and the line unchanged.
+func ParseTags(line string) (map[string]string, string) {
+	tags := make(map[string]string)
+
+	if !strings.HasPrefix(line, "@") {
+		return tags, line
+	}
+
+	raw := line[1:]
+	rest := ""
+	if i := strings.IndexByte(raw, ' '); i >= 0 {
+		raw, rest = raw[:i], strings.TrimLeft(raw[i+1:], " ")
+	}
+
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+
+		key, value := tag, ""
+		if i := strings.IndexByte(tag, '='); i >= 0 {
+			key, value = tag[:i], tagUnescaper.Replace(tag[i+1:])
+		}
+
+		tags[key] = value
+	}
+
+	return tags, rest
+}
@@ -0,0 +1,35 @@
+package common
+
+import "testing"
+
+func TestParseTags(t *testing.T) {
+	tags, rest := ParseTags("PRIVMSG #channel :hello")
+	if len(tags) != 0 || rest != "PRIVMSG #channel :hello" {
+		t.Error(tags, rest, "Untagged line was changed.")
+	}
+
+	line := `@badges=;mod=1;reply-parent-msg-id=abc;system-msg=a\sb\:c ` +
+		":tmi.twitch.tv USERSTATE #channel"
+
+	tags, rest = ParseTags(line)
+	if rest != ":tmi.twitch.tv USERSTATE #channel" {
+		t.Error(rest, "Rest of the line was not as expected.")
+	}
+
+	expected := map[string]string{
+		"badges":              "",
+		"mod":                 "1",
+		"reply-parent-msg-id": "abc",
+		"system-msg":          "a b;c",
+	}
+
+	if len(tags) != len(expected) {
+		t.Error(tags, "Tag count was not as expected.")
+	}
+
+	for key, value := range expected {
+		if tags[key] != value {
+			t.Error(key, tags[key], "Tag value was not as expected.")
+		}
+	}
+}
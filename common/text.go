@@ -0,0 +1,63 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// MaxMessageLength is the most characters Twitch accepts in one message.
+const MaxMessageLength = 500
+
+// ErrLineBreak is returned for text that would break out of its IRC line.
+var ErrLineBreak = errors.New("Text must not contain line breaks.")
+
+// SanitizeText makes text safe to put in an IRC line. Line breaks would let
+//   the text inject extra commands, so they are an error. Any other control
+//   characters are removed.
+func SanitizeText(text string) (string, error) {
+	if strings.ContainsAny(text, "\r\n") {
+		return "", ErrLineBreak
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text), nil
+}
+
+// SplitText breaks text into pieces of at most limit characters. Breaks
+//   are made between words, unless a single word is too long to fit.
+func SplitText(text string, limit int) []string {
+	var pieces []string
+	var piece []rune
+
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+
+		// Start a new piece if the word doesn't fit on the current one.
+		if len(piece) > 0 && len(piece)+1+len(runes) > limit {
+			pieces = append(pieces, string(piece))
+			piece = piece[:0]
+		}
+
+		// A word longer than the limit has to be broken up itself.
+		for len(runes) > limit {
+			pieces = append(pieces, string(runes[:limit]))
+			runes = runes[limit:]
+		}
+
+		if len(piece) > 0 {
+			piece = append(piece, ' ')
+		}
+		piece = append(piece, runes...)
+	}
+
+	if len(piece) > 0 {
+		pieces = append(pieces, string(piece))
+	}
+
+	return pieces
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+	text, err := SanitizeText("hello\x00 \x07there\x7f")
+	if err != nil || text != "hello there" {
+		t.Error(text, err, "Control characters were not removed.")
+	}
+
+	for _, injected := range []string{"hi\r\nQUIT", "hi\nJOIN #other", "hi\r"} {
+		if _, err := SanitizeText(injected); err != ErrLineBreak {
+			t.Error(injected, "Line break was not rejected.")
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	pieces := SplitText("the quick brown fox", 10)
+	expected := []string{"the quick", "brown fox"}
+	if strings.Join(pieces, "|") != strings.Join(expected, "|") {
+		t.Error(pieces, "Text was not split at word boundaries.")
+	}
+
+	pieces = SplitText("a abcdefghijkl b", 5)
+	expected = []string{"a", "abcde", "fghij", "kl b"}
+	if strings.Join(pieces, "|") != strings.Join(expected, "|") {
+		t.Error(pieces, "Long word was not broken up.")
+	}
+
+	long := strings.Repeat("ñandú ", 200)
+	for _, piece := range SplitText(long, MaxMessageLength) {
+		if len([]rune(piece)) > MaxMessageLength {
+			t.Error(len([]rune(piece)), "Piece was longer than the limit.")
+		}
+	}
+
+	if len(SplitText("short", MaxMessageLength)) != 1 {
+		t.Error("Short text was split.")
+	}
+}
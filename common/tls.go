@@ -0,0 +1,110 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+)
+
+// NewServerTLS returns the TLS config every listener uses, or nil when the
+//   config has no certificate and TLS is off. With mutual TLS, only clients
+//   with a certificate signed by the CA are let in.
+func NewServerTLS(config TLSConfig) (*tls.Config, error) {
+	if config.CertPath == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.CertPath, config.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.Mutual {
+		if config.CAPath == "" {
+			return nil, errors.New("Mutual TLS needs a CA to check clients against.")
+		}
+
+		server.ClientCAs, err = loadCA(config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return server, nil
+}
+
+// NewClientTLS returns the TLS config every dialer uses, or nil when TLS is
+//   off. Servers are checked against the CA, or the system's roots without
+//   one. With mutual TLS, the same certificate is presented to servers, so
+//   it has to be good for both ends.
+func NewClientTLS(config TLSConfig) (*tls.Config, error) {
+	if config.CertPath == "" {
+		return nil, nil
+	}
+
+	client := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.CAPath != "" {
+		pool, err := loadCA(config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		client.RootCAs = pool
+	}
+
+	if config.Mutual {
+		cert, err := tls.LoadX509KeyPair(config.CertPath, config.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		client.Certificates = []tls.Certificate{cert}
+	}
+
+	return client, nil
+}
+
+// dialTLS wraps a connection that was just dialed to addr in TLS, and
+//   finishes the handshake.
+func dialTLS(conn net.Conn, addr string, config *tls.Config) (net.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	secure := tls.Client(conn, config)
+	if err := secure.Handshake(); err != nil {
+		return nil, err
+	}
+
+	return secure, nil
+}
+
+// loadCA reads a file of PEM certificates into a pool.
+func loadCA(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("No certificates found in the CA file: " + path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a certificate for localhost and its key to dir, signed
+//   by parent, or by itself as a CA when there is no parent.
+func writeCert(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbdemo-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "member", ca, caKey)
+	writeCert(t, dir, "stranger", nil, nil)
+
+	member := TLSConfig{
+		CertPath: filepath.Join(dir, "member.crt"),
+		KeyPath:  filepath.Join(dir, "member.key"),
+		CAPath:   filepath.Join(dir, "ca.crt"),
+		Mutual:   true,
+	}
+
+	serverTLS, err := NewServerTLS(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, _, err := Listen("localhost", 0, serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	node, err := NewNode(listener, &echoWorker{}, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	addr := listener.Addr().String()
+
+	clientTLS, err := NewClientTLS(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(addr, ClientConfig{TLS: clientTLS})
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Server.Do", "secret work", &reply); err != nil || reply != "secret work" {
+		t.Error(reply, err, "A cluster member should get through.")
+	}
+
+	// Plaintext doesn't get through a TLS listener.
+	if _, err := DialHTTP(addr, time.Second, ClientConfig{}); err == nil {
+		t.Error("A plaintext client should not get through.")
+	}
+
+	// Neither does a client with a certificate from somewhere else.
+	stranger, err := NewClientTLS(TLSConfig{
+		CertPath: filepath.Join(dir, "stranger.crt"),
+		KeyPath:  filepath.Join(dir, "stranger.key"),
+		CAPath:   filepath.Join(dir, "ca.crt"),
+		Mutual:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DialHTTP(addr, time.Second, ClientConfig{TLS: stranger}); err == nil {
+		t.Error("A client outside the cluster should not get through.")
+	}
+
+	// Without a certificate, TLS is off.
+	if off, err := NewServerTLS(TLSConfig{}); off != nil || err != nil {
+		t.Error(off, err, "TLS should be off without a certificate.")
+	}
+}
@@ -1,35 +1,304 @@
 package common
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrJobStopped is what a WorkGroup fails with when one of its jobs returns
+//   without an error. The jobs are vital, so stopping at all is a failure.
+var ErrJobStopped = errors.New("A job stopped without an error.")
+
+// ErrHalted is what a worker returns when it was told to stop. It is never
+//   restarted, whatever the job's policy.
+var ErrHalted = errors.New("The worker was instructed to stop.")
+
+// ErrSignaled is what a WorkGroup is cancelled with when one of the signals
+//   it handles arrives.
+var ErrSignaled = errors.New("Received a signal to stop.")
+
+// ErrShutdown is what a WorkGroup is cancelled with when it is shut down
+//   before anything failed.
+var ErrShutdown = errors.New("The work group was shut down.")
+
+// The restart policies a supervised job can have.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// A RestartPolicy says what happens when a job returns. A job that isn't
+//   restarted stops the whole group, unless it returned without an error
+//   under on-failure. Restarts wait Backoff, doubling each time up to
+//   MaxBackoff, and a MaxRestarts of zero means there is no limit.
+type RestartPolicy struct {
+	Policy      string
+	MaxRestarts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewRestartPolicy returns the RestartPolicy the config describes.
+func NewRestartPolicy(config RestartConfig) RestartPolicy {
+	return RestartPolicy{
+		config.Policy,
+		config.MaxRestarts,
+		time.Duration(config.Backoff) * time.Millisecond,
+		time.Duration(config.MaxBackoff) * time.Millisecond,
+	}
+}
+
+// JobStats is how a supervised job has been doing, for a node's status.
+type JobStats struct {
+	Name      string `json:"name"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"lastError,omitempty"`
+}
+
 // A WorkGroup is wrapper to make managing multiple vital
-//   concurrent functions cleaner
+//   concurrent functions cleaner. The first job to fail for good, or a
+//   signal, cancels the group's context, and Shutdown then runs the shutdown
+//   hooks and waits for the rest of the jobs.
 type WorkGroup struct {
-	functions []func() error
+	sync.Mutex
+	functions []*job
+	hooks     []func() error
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
 	done      chan error
 }
 
+// A job is a function in a WorkGroup along with how it is supervised.
+type job struct {
+	work   func(context.Context) error
+	policy RestartPolicy
+	stats  JobStats
+}
+
 // NewWorkGroup returns a new work group.
 func NewWorkGroup() *WorkGroup {
+	return NewWorkGroupContext(context.Background())
+}
+
+// NewWorkGroupContext returns a new work group that is also cancelled when
+//   ctx is.
+func NewWorkGroupContext(ctx context.Context) *WorkGroup {
 	jobs := WorkGroup{}
-	jobs.done = make(chan error)
+	jobs.ctx, jobs.cancel = context.WithCancelCause(ctx)
 	return &jobs
 }
 
 // Add accepts a function to start work on concurrently.
 func (jobs *WorkGroup) Add(work func() error) {
-	jobs.functions = append(jobs.functions, work)
+	jobs.AddContext(func(context.Context) error {
+		return work()
+	})
+}
+
+// AddContext accepts a function to start work on concurrently, which should
+//   return once the context it is given is cancelled.
+func (jobs *WorkGroup) AddContext(work func(ctx context.Context) error) {
+	name := "job " + strconv.Itoa(len(jobs.functions)+1)
+	jobs.Supervise(name, RestartPolicy{Policy: RestartNever}, work)
+}
+
+// Supervise accepts a function to start work on concurrently, which is
+//   restarted according to the policy. The name is what its stats are
+//   reported under.
+func (jobs *WorkGroup) Supervise(name string, policy RestartPolicy, work func(ctx context.Context) error) {
+	jobs.functions = append(jobs.functions, &job{
+		work:   work,
+		policy: policy,
+		stats:  JobStats{Name: name},
+	})
+}
+
+// Stats returns how every job has been doing, in the order they were added.
+func (jobs *WorkGroup) Stats() []JobStats {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	stats := make([]JobStats, len(jobs.functions))
+	for i, j := range jobs.functions {
+		stats[i] = j.stats
+	}
+
+	return stats
+}
+
+// OnShutdown accepts a function to run when the group shuts down. They run
+//   in the order they were added.
+func (jobs *WorkGroup) OnShutdown(hook func() error) {
+	jobs.hooks = append(jobs.hooks, hook)
+}
+
+// Context returns the context the jobs are given.
+func (jobs *WorkGroup) Context() context.Context {
+	return jobs.ctx
+}
+
+// HandleSignals cancels the group when one of the signals arrives. Without
+//   any, SIGINT and SIGTERM are handled.
+func (jobs *WorkGroup) HandleSignals(signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	notify := make(chan os.Signal, 1)
+	signal.Notify(notify, signals...)
+
+	go func() {
+		defer signal.Stop(notify)
+
+		select {
+		case sig := <-notify:
+			jobs.cancel(fmt.Errorf("%w (%s)", ErrSignaled, sig))
+		case <-jobs.ctx.Done():
+		}
+	}()
 }
 
 // Start goes through all added functions and starts them.
 func (jobs *WorkGroup) Start() {
-	for _, work := range jobs.functions {
-		go func(job func() error) {
-			err := job()
+	// The channel has room for every job, so none of them block on
+	//   finishing after the first one has already failed.
+	jobs.done = make(chan error, len(jobs.functions))
+
+	for _, j := range jobs.functions {
+		go func(j *job) {
+			err := jobs.supervise(j)
+			if err != nil {
+				jobs.cancel(err)
+			}
+
 			jobs.done <- err
-		}(work)
+		}(j)
 	}
 }
 
-// Wait watches the channel for any errors and returns them.
+// supervise runs a job until its policy says to stop, and returns the error
+//   that should stop the group, if any.
+func (jobs *WorkGroup) supervise(j *job) error {
+	delay := j.policy.Backoff
+	for {
+		started := time.Now()
+		err := j.work(jobs.ctx)
+
+		// Once the group is stopping, nothing is restarted.
+		if jobs.ctx.Err() != nil || errors.Is(err, ErrHalted) {
+			return err
+		}
+
+		switch j.policy.Policy {
+		case RestartAlways:
+		case RestartOnFailure:
+			if err == nil {
+				return nil
+			}
+		default:
+			if err == nil {
+				return ErrJobStopped
+			}
+			return err
+		}
+
+		if err == nil {
+			err = ErrJobStopped
+		}
+
+		jobs.Lock()
+		restarts := j.stats.Restarts
+		if j.policy.MaxRestarts > 0 && restarts >= j.policy.MaxRestarts {
+			jobs.Unlock()
+			return fmt.Errorf("The job %s gave up after %d restarts: %w", j.stats.Name, restarts, err)
+		}
+		j.stats.Restarts++
+		j.stats.LastError = err.Error()
+		jobs.Unlock()
+
+		// A job that ran for a while before stopping starts its backoff
+		//   over.
+		if j.policy.MaxBackoff > 0 && time.Since(started) > j.policy.MaxBackoff {
+			delay = j.policy.Backoff
+		}
+
+		fmt.Println("Restarting the job", j.stats.Name, "in", delay, "after:", err)
+
+		select {
+		case <-jobs.ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if j.policy.MaxBackoff > 0 && delay > j.policy.MaxBackoff {
+			delay = j.policy.MaxBackoff
+		}
+	}
+}
+
+// Wait blocks until the first job fails or the group is cancelled, and
+//   returns why.
 func (jobs *WorkGroup) Wait() error {
-	return <-jobs.done
+	<-jobs.ctx.Done()
+	return context.Cause(jobs.ctx)
+}
+
+// Shutdown cancels the group, runs the shutdown hooks, and waits for the
+//   jobs to stop. Anything not done by the timeout is given up on. Every
+//   error along the way is returned, starting with the one that stopped the
+//   group.
+func (jobs *WorkGroup) Shutdown(timeout time.Duration) error {
+	jobs.cancel(ErrShutdown)
+	cause := context.Cause(jobs.ctx)
+	deadline := time.After(timeout)
+
+	errs := []error{cause}
+
+	// Hooks can take a while, like a node finishing its work, so they are
+	//   held to the deadline too.
+	hooksDone := make(chan []error, 1)
+	go func() {
+		var hookErrs []error
+		for _, hook := range jobs.hooks {
+			if err := hook(); err != nil {
+				hookErrs = append(hookErrs, err)
+			}
+		}
+
+		hooksDone <- hookErrs
+	}()
+
+	select {
+	case hookErrs := <-hooksDone:
+		errs = append(errs, hookErrs...)
+	case <-deadline:
+		errs = append(errs, errors.New("The shutdown hooks did not finish in time."))
+		return errors.Join(errs...)
+	}
+
+	// The job that stopped the group is already accounted for, as are jobs
+	//   that stopped because they were cancelled.
+	for stopped := 0; stopped < len(jobs.functions); stopped++ {
+		select {
+		case err := <-jobs.done:
+			if err != nil && err != cause && !errors.Is(err, context.Canceled) {
+				errs = append(errs, err)
+			}
+		case <-deadline:
+			running := len(jobs.functions) - stopped
+			errs = append(errs, fmt.Errorf("%d jobs did not stop in time.", running))
+			return errors.Join(errs...)
+		}
+	}
+
+	return errors.Join(errs...)
 }
@@ -1,7 +1,11 @@
 package common
 
 import (
+	"context"
 	"errors"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -33,3 +37,135 @@ func TestWorkGroup(t *testing.T) {
 		t.Error("Unexpected error.")
 	}
 }
+
+func TestWorkGroupShutdown(t *testing.T) {
+	jobs := NewWorkGroup()
+
+	// A job that listens to its context stops on its own.
+	jobs.AddContext(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// A job that doesn't is waited on until the deadline.
+	jobs.Add(func() error {
+		time.Sleep(5 * time.Second)
+		return nil
+	})
+
+	jobs.Add(func() error {
+		return errors.New("expected")
+	})
+
+	var order []int
+	jobs.OnShutdown(func() error {
+		order = append(order, 1)
+		return errors.New("hook")
+	})
+	jobs.OnShutdown(func() error {
+		order = append(order, 2)
+		return nil
+	})
+
+	jobs.Start()
+
+	if err := jobs.Wait(); err.Error() != "expected" {
+		t.Error(err, "Unexpected error.")
+	}
+
+	if jobs.Context().Err() == nil {
+		t.Error("The context was not cancelled.")
+	}
+
+	err := jobs.Shutdown(100 * time.Millisecond)
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Error(order, "The hooks did not run in order.")
+	}
+
+	expected := "expected\nhook\n1 jobs did not stop in time."
+	if err == nil || err.Error() != expected {
+		t.Error(err, "Not every error was collected.")
+	}
+}
+
+func TestWorkGroupSignal(t *testing.T) {
+	jobs := NewWorkGroup()
+	jobs.HandleSignals(syscall.SIGUSR1)
+	jobs.AddContext(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	jobs.Start()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-jobs.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("The signal did not cancel the work group.")
+	}
+
+	if err := jobs.Shutdown(time.Second); !errors.Is(err, ErrSignaled) || !Stopped(err) {
+		t.Error(err, "The signal was not the reason for stopping.")
+	}
+}
+
+func TestWorkGroupSupervise(t *testing.T) {
+	jobs := NewWorkGroup()
+
+	// A job that fails a couple of times and then runs is kept alive.
+	failures := 0
+	jobs.Supervise("flaky", RestartPolicy{RestartOnFailure, 5, time.Millisecond, 10 * time.Millisecond}, func(ctx context.Context) error {
+		if failures < 2 {
+			failures++
+			return errors.New("flaky")
+		}
+
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// A job that never stops failing gives up and stops the group.
+	jobs.Supervise("broken", RestartPolicy{RestartOnFailure, 3, time.Millisecond, 10 * time.Millisecond}, func(ctx context.Context) error {
+		return errors.New("broken")
+	})
+
+	jobs.Start()
+
+	err := jobs.Wait()
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatal(err, "The broken job should have stopped the group.")
+	}
+
+	stats := jobs.Stats()
+	if len(stats) != 2 {
+		t.Fatal(stats, "Every job should have stats.")
+	}
+
+	if stats[0].Name != "flaky" || stats[0].Restarts != 2 || stats[0].LastError != "flaky" {
+		t.Error(stats[0], "The flaky job's restarts were not counted.")
+	}
+
+	if stats[1].Restarts != 3 || stats[1].LastError != "broken" {
+		t.Error(stats[1], "The broken job's restarts were not counted.")
+	}
+
+	jobs.Shutdown(time.Second)
+
+	// A halted job is never restarted, even when it should always be.
+	jobs = NewWorkGroup()
+	jobs.Supervise("halted", RestartPolicy{RestartAlways, 0, time.Millisecond, time.Millisecond}, func(ctx context.Context) error {
+		return ErrHalted
+	})
+	jobs.Start()
+
+	if err := jobs.Wait(); err != ErrHalted {
+		t.Error(err, "The halted job should have stopped the group.")
+	}
+
+	if stats := jobs.Stats(); stats[0].Restarts != 0 {
+		t.Error(stats[0], "The halted job was restarted.")
+	}
+}
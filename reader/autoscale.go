@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// NewAutoscaler creates the autoscaler for the reader tier. It asks every
+//   reader the factory knows about how many channels it is listening to, and
+//   asks the launcher for more or fewer nodes.
+func NewAutoscaler(config *common.Config, factory *ConnectionFactory, launcher common.Launcher) *Autoscaler {
+	scaling := config.Master.Scaling
+
+	return &Autoscaler{
+		common.NewAutoscaler(scaling, launcher, "reader"),
+		scaling,
+		factory,
+	}
+}
+
+// An Autoscaler adds reader nodes when the tier nears its channel capacity,
+//   and consolidates channels onto fewer nodes when it sits mostly idle.
+type Autoscaler struct {
+	*common.Autoscaler
+	scaling common.ScalingConfig
+	factory *ConnectionFactory
+}
+
+// A reading is what a single reader reported during a check.
+type reading struct {
+	conn   *api.Connection
+	status *Status
+}
+
+// room returns how many more channels the reader can listen to.
+func (r reading) room() int {
+	return r.status.MaxChannels - len(r.status.Channels)
+}
+
+// Scale checks on the reader nodes at the configured frequency until
+//   ctx is cancelled.
+func (autoscaler *Autoscaler) Scale(ctx context.Context) error {
+	return autoscaler.Run(ctx, autoscaler.check)
+}
+
+// check compares the channel count with the capacity and scales if needed.
+func (autoscaler *Autoscaler) check() error {
+	readings := readAll(autoscaler.factory)
+	nodes := len(readings)
+
+	channels, capacity := 0, 0
+	for _, r := range readings {
+		channels += len(r.status.Channels)
+		capacity += r.status.MaxChannels
+	}
+
+	// Without a capacity there is nothing to scale towards, other than the
+	//   bounds.
+	over, under := false, false
+	if capacity > 0 {
+		target := autoscaler.scaling.TargetUtilization
+		utilization := float64(channels) / float64(capacity)
+
+		over = utilization > target
+		under = utilization < target*autoscaler.scaling.ScaleDownRatio
+	}
+
+	switch autoscaler.Decide(nodes, over, under) {
+	case common.ScaleUp:
+		return autoscaler.Launch()
+	case common.ScaleDown:
+		return autoscaler.consolidate(readings)
+	}
+
+	return nil
+}
+
+// readAll asks every reader the factory knows about for its status. Readers
+//   that can't be reached are left out this time, and forgotten once they
+//   have left the registry. Each reading has its own copy of the status, so
+//   placing channels on it doesn't touch the one the load balancer uses.
+func readAll(factory *ConnectionFactory) []reading {
+	var readings []reading
+	for _, conn := range factory.Connections() {
+		status := &Status{}
+		if err := conn.ReadStatus(status); err != nil {
+			fmt.Println("Reader did not report its status:", conn, err)
+			factory.ForgetGone(conn)
+			continue
+		}
+
+		readings = append(readings, reading{conn, status})
+	}
+
+	return readings
+}
+
+// consolidate moves every channel off the reader with the fewest, and then
+//   stops it. Nodes the launcher manages are preferred, since those are the
+//   ones it can stop.
+func (autoscaler *Autoscaler) consolidate(readings []reading) error {
+	managed := make(map[string]bool)
+	for _, node := range autoscaler.Launcher().Nodes() {
+		managed[node] = true
+	}
+
+	emptiest := -1
+	for i, r := range readings {
+		if emptiest == -1 {
+			emptiest = i
+			continue
+		}
+
+		best := readings[emptiest]
+		isManaged, bestManaged := managed[r.conn.String()], managed[best.conn.String()]
+		if isManaged != bestManaged {
+			if isManaged {
+				emptiest = i
+			}
+			continue
+		}
+
+		if len(r.status.Channels) < len(best.status.Channels) {
+			emptiest = i
+		}
+	}
+
+	if emptiest == -1 {
+		return nil
+	}
+
+	victim := readings[emptiest]
+	rest := append(append([]reading{}, readings[:emptiest]...), readings[emptiest+1:]...)
+
+	// Only consolidate if the other readers can take every channel.
+	room := 0
+	for _, r := range rest {
+		room += r.room()
+	}
+	if room < len(victim.status.Channels) {
+		return nil
+	}
+
+	fmt.Println("Consolidating channels off a reader node:", victim.conn)
+
+	for _, channel := range victim.status.Channels {
+		if err := autoscaler.migrate(channel, victim, rest); err != nil {
+			return err
+		}
+	}
+
+	if managed[victim.conn.String()] {
+		return autoscaler.Launcher().Stop(victim.conn.String())
+	}
+
+	_, err := victim.conn.Send("HALT")
+	return err
+}
+
+// migrate moves a channel to the reader with the most room. The channel is
+//   joined on the new reader before it is parted on the old one, so no
+//   messages are missed.
+func (autoscaler *Autoscaler) migrate(channel string, from reading, to []reading) error {
+	if _, err := place(channel, to); err != nil {
+		return err
+	}
+
+	_, err := from.conn.Send("PART " + channel)
+	return err
+}
+
+// place joins a channel on the reader with the most room, and returns
+//   which reader that was.
+func place(channel string, to []reading) (string, error) {
+	if len(to) == 0 {
+		return "", errors.New("No reader to move the channel to: " + channel)
+	}
+
+	roomiest := 0
+	for i, r := range to {
+		if r.room() > to[roomiest].room() {
+			roomiest = i
+		}
+	}
+
+	target := to[roomiest]
+	if _, err := target.conn.Send("JOIN " + channel); err != nil {
+		return "", err
+	}
+	target.status.Channels = append(target.status.Channels, channel)
+
+	return target.conn.String(), nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// A fakeReader keeps the channels it is told to join, and is halted by
+//   HALT.
+type fakeReader struct {
+	sync.Mutex
+	channels    []string
+	maxChannels int
+	halted      bool
+}
+
+func (worker *fakeReader) Do(work string) (string, error) {
+	worker.Lock()
+	defer worker.Unlock()
+
+	parts := strings.Fields(work)
+	switch parts[0] {
+	case "JOIN":
+		worker.channels = append(worker.channels, parts[1])
+	case "PART":
+		for i, channel := range worker.channels {
+			if channel == parts[1] {
+				worker.channels = append(worker.channels[:i], worker.channels[i+1:]...)
+				break
+			}
+		}
+	case "HALT":
+		worker.halted = true
+	}
+
+	return "", nil
+}
+
+func (worker *fakeReader) Status(requestTime time.Time) balancer.Status {
+	worker.Lock()
+	defer worker.Unlock()
+
+	return &Status{
+		Channels:    append([]string(nil), worker.channels...),
+		MaxChannels: worker.maxChannels,
+	}
+}
+
+func (worker *fakeReader) Channels() []string {
+	worker.Lock()
+	defer worker.Unlock()
+
+	return append([]string(nil), worker.channels...)
+}
+
+// A fakeLauncher keeps track of the nodes it was asked to stop.
+type fakeLauncher struct {
+	nodes   []string
+	stopped []string
+}
+
+func (launcher *fakeLauncher) Launch() (string, error) {
+	return "", nil
+}
+
+func (launcher *fakeLauncher) Stop(node string) error {
+	launcher.stopped = append(launcher.stopped, node)
+	return nil
+}
+
+func (launcher *fakeLauncher) Nodes() []string {
+	return launcher.nodes
+}
+
+// newTestTier serves a reader for each capacity with the channels it
+//   already has, and returns a factory that knows every one of them along
+//   with the registry they are in.
+func newTestTier(t *testing.T, readers ...*fakeReader) (*ConnectionFactory, *common.FileRegistry, []string) {
+	dir, err := os.MkdirTemp("", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	registry := common.NewFileRegistry(filepath.Join(dir, "nodes.txt"), time.Minute)
+	factory := NewConnectionFactory(NewStatusFactory(), common.ClientConfig{}, registry)
+
+	var names []string
+	for _, worker := range readers {
+		listener, port, err := common.Listen("localhost", 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+
+		node, err := common.NewNode(listener, worker, time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go node.ListenAndServe()
+
+		name := "localhost:" + strconv.Itoa(port)
+		if err := registry.Register(name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := factory.Create(name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	return factory, registry, names
+}
+
+func TestPlace(t *testing.T) {
+	full := &fakeReader{channels: []string{"#a"}, maxChannels: 2}
+	roomy := &fakeReader{channels: []string{"#b"}, maxChannels: 5}
+	factory, _, names := newTestTier(t, full, roomy)
+
+	readings := readAll(factory)
+	node, err := place("#c", readings)
+	if err != nil || node != names[1] {
+		t.Error(node, err, "The channel should go to the reader with the most room.")
+	}
+
+	if channels := roomy.Channels(); len(channels) != 2 || channels[1] != "#c" {
+		t.Error(channels, "The reader was not told to join the channel.")
+	}
+
+	// The reading counts the channel, so the next placement sees it.
+	for _, r := range readings {
+		if r.conn.String() == node && r.room() != 3 {
+			t.Error(r.room(), "The placed channel was not counted against the reader.")
+		}
+	}
+
+	if _, err := place("#d", nil); err == nil {
+		t.Error("A channel can't be placed without readers.")
+	}
+}
+
+func TestConsolidate(t *testing.T) {
+	busy := &fakeReader{channels: []string{"#a", "#b", "#c"}, maxChannels: 5}
+	quiet := &fakeReader{channels: []string{"#d"}, maxChannels: 5}
+	managed := &fakeReader{channels: []string{"#e", "#f"}, maxChannels: 5}
+	factory, _, names := newTestTier(t, busy, quiet, managed)
+
+	config := &common.Config{}
+	launcher := &fakeLauncher{nodes: []string{names[2]}}
+	autoscaler := NewAutoscaler(config, factory, launcher)
+
+	// The launcher can only stop the nodes it manages, so one of them goes
+	//   even though another reader is emptier.
+	if err := autoscaler.consolidate(readAll(factory)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(launcher.stopped) != 1 || launcher.stopped[0] != names[2] {
+		t.Error(launcher.stopped, "The managed reader should have been stopped.")
+	}
+
+	if channels := managed.Channels(); len(channels) != 0 {
+		t.Error(channels, "The channels were not parted on the stopped reader.")
+	}
+
+	if joined := len(busy.Channels()) + len(quiet.Channels()); joined != 6 {
+		t.Error(busy.Channels(), quiet.Channels(), "The channels were not joined elsewhere.")
+	}
+
+}
+
+func TestConsolidateUnmanaged(t *testing.T) {
+	busy := &fakeReader{channels: []string{"#a", "#b", "#c"}, maxChannels: 4}
+	quiet := &fakeReader{channels: []string{"#d", "#e"}, maxChannels: 5}
+	factory, _, _ := newTestTier(t, busy, quiet)
+
+	autoscaler := NewAutoscaler(&common.Config{}, factory, &fakeLauncher{})
+
+	// The emptiest reader is only halted when the rest have room for its
+	//   channels.
+	if err := autoscaler.consolidate(readAll(factory)); err != nil {
+		t.Fatal(err)
+	}
+	if quiet.halted || len(busy.Channels()) != 3 {
+		t.Error(busy.Channels(), "Channels were moved to a reader without room.")
+	}
+
+	busy.Lock()
+	busy.maxChannels = 10
+	busy.Unlock()
+
+	if err := autoscaler.consolidate(readAll(factory)); err != nil {
+		t.Fatal(err)
+	}
+	if !quiet.halted || len(busy.Channels()) != 5 {
+		t.Error(busy.Channels(), "The emptiest reader should have been halted.")
+	}
+}
+
+func TestChannelServiceMove(t *testing.T) {
+	draining := &fakeReader{channels: []string{"#a", "#b"}, maxChannels: 5}
+	other := &fakeReader{maxChannels: 5}
+	factory, _, names := newTestTier(t, draining, other)
+
+	service := NewChannelService(factory)
+
+	var moved int
+	args := api.MoveArgs{Node: names[0], Channels: []string{"#a", "#b"}}
+	if err := service.Move(args, &moved); err != nil || moved != 2 {
+		t.Error(moved, err, "The channels were not moved.")
+	}
+
+	// The draining reader parts them itself, and none are joined on it.
+	if channels := other.Channels(); len(channels) != 2 {
+		t.Error(channels, "The channels should have gone to the other reader.")
+	}
+	if channels := draining.Channels(); len(channels) != 2 {
+		t.Error(channels, "The draining reader should be left to part its channels.")
+	}
+
+}
+
+func TestChannelServiceMoveNowhere(t *testing.T) {
+	draining := &fakeReader{channels: []string{"#a"}, maxChannels: 5}
+	factory, _, names := newTestTier(t, draining)
+
+	// The only reader is the one draining, so there is nowhere to go.
+	var moved int
+	args := api.MoveArgs{Node: names[0], Channels: []string{"#a"}}
+	if err := NewChannelService(factory).Move(args, &moved); err == nil || moved != 0 {
+		t.Error(moved, "A channel was moved without another reader.")
+	}
+}
+
+func TestReadAllForgetsGone(t *testing.T) {
+	factory, registry, names := newTestTier(t, &fakeReader{maxChannels: 5})
+
+	// A reader that doesn't answer but is still registered is only skipped.
+	if _, err := factory.Create("localhost:1"); err != nil {
+		t.Fatal(err)
+	}
+	registry.Register("localhost:1")
+
+	if readings := readAll(factory); len(readings) != 1 {
+		t.Error(readings, "The reader that did not answer should be skipped.")
+	}
+	if conns := factory.Connections(); len(conns) != 2 {
+		t.Error(conns, "A registered reader should not be forgotten.")
+	}
+
+	// Once it has left the registry, it is forgotten.
+	registry.Deregister("localhost:1")
+	readAll(factory)
+
+	conns := factory.Connections()
+	if len(conns) != 1 || conns[0].String() != names[0] {
+		t.Error(conns, "A reader that left the registry should be forgotten.")
+	}
+}
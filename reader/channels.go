@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// NewChannelService creates the service readers use to hand their channels
+//   to the rest of the tier, and operators use to manage channels.
+func NewChannelService(factory *ConnectionFactory) *ChannelService {
+	return &ChannelService{factory}
+}
+
+// A ChannelService runs on the reader master. A reader that is draining
+//   asks it to move its channels elsewhere before it stops listening.
+//   Channels joined through it go to the reader with the most room.
+type ChannelService struct {
+	factory *ConnectionFactory
+}
+
+// Move joins each channel on the other reader with the most room, and
+//   replies with how many were moved. The reader asking is left to part the
+//   channels itself.
+func (service *ChannelService) Move(args api.MoveArgs, moved *int) error {
+	var others []reading
+	for _, r := range readAll(service.factory) {
+		if r.conn.String() != args.Node {
+			others = append(others, r)
+		}
+	}
+
+	for _, channel := range args.Channels {
+		if _, err := place(channel, others); err != nil {
+			return err
+		}
+
+		*moved++
+	}
+
+	fmt.Println("Moved channels off a draining reader:", args.Node, *moved)
+	return nil
+}
+
+// List replies with the channels each reader is listening to.
+func (service *ChannelService) List(unused bool, channels *map[string][]string) error {
+	byReader := make(map[string][]string)
+	for _, r := range readAll(service.factory) {
+		byReader[r.conn.String()] = r.status.Channels
+	}
+
+	*channels = byReader
+	return nil
+}
+
+// Join joins a channel on the reader with the most room, and replies with
+//   which reader that was.
+func (service *ChannelService) Join(channel string, node *string) error {
+	readings := readAll(service.factory)
+	if r, ok := listening(channel, readings); ok {
+		return errors.New("Already listening on the channel: " + channel + " on " + r.conn.String())
+	}
+
+	placed, err := place(channel, readings)
+	*node = placed
+	return err
+}
+
+// Part parts a channel on the reader listening to it, and replies with
+//   which reader that was.
+func (service *ChannelService) Part(channel string, node *string) error {
+	r, ok := listening(channel, readAll(service.factory))
+	if !ok {
+		return errors.New("No reader is listening on the channel: " + channel)
+	}
+
+	*node = r.conn.String()
+	_, err := r.conn.Send("PART " + channel)
+	return err
+}
+
+// listening returns the reader listening on a channel, if there is one.
+func listening(channel string, readings []reading) (reading, bool) {
+	for _, r := range readings {
+		for _, c := range r.status.Channels {
+			if c == channel {
+				return r, true
+			}
+		}
+	}
+
+	return reading{}, false
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
-	"strconv"
-	"strings"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
 )
 
-// NewConnectionFactory returns an implementation of NodeFactory
-func NewConnectionFactory(factory balancer.StatusFactory) balancer.NodeFactory {
-	return &ConnectionFactory{factory}
+// NewConnectionFactory returns an implementation of NodeFactory. The
+//   connections it creates make their calls as the client config says, and
+//   are kept until their node leaves the registry.
+func NewConnectionFactory(factory balancer.StatusFactory, client common.ClientConfig, registry common.Registry) *ConnectionFactory {
+	return &ConnectionFactory{
+		status:      factory,
+		client:      client,
+		registry:    registry,
+		connections: make(map[string]*api.Connection),
+	}
 }
 
 func NewStatusFactory() balancer.StatusFactory {
@@ -18,27 +29,70 @@ func NewStatusFactory() balancer.StatusFactory {
 }
 
 // The ConnectionFactory specifically required to do the Reader load balancing.
+//   It keeps every connection it creates so the reader master can tell
+//   which reader is listening to which channels.
 type ConnectionFactory struct {
-	status balancer.StatusFactory
+	sync.Mutex
+	status      balancer.StatusFactory
+	client      common.ClientConfig
+	registry    common.Registry
+	connections map[string]*api.Connection
 }
 
 // Create takes the connection info and creates the connection struct.
 func (factory *ConnectionFactory) Create(connInfo string) (balancer.NodeConnection, error) {
-	parts := strings.Split(connInfo, ":")
-
-	port, err := strconv.Atoi(parts[1])
+	conn, err := api.NewConnection(connInfo, factory.status.Create(), factory.client, factory.client.CallTimeout())
 	if err != nil {
 		return nil, err
 	}
 
-	conn := Connection{}
-	conn.host = parts[0]
-	conn.port = port
-	conn.jobCount = 0
-	conn.status = factory.status.Create()
-	conn.client = nil
+	factory.Lock()
+	factory.connections[connInfo] = conn
+	factory.Unlock()
 
-	return &conn, nil
+	return conn, nil
+}
+
+// Connections returns every connection the factory has created, in order
+//   of their address.
+func (factory *ConnectionFactory) Connections() []*api.Connection {
+	factory.Lock()
+	defer factory.Unlock()
+
+	names := make([]string, 0, len(factory.connections))
+	for name := range factory.connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	connections := make([]*api.Connection, len(names))
+	for i, name := range names {
+		connections[i] = factory.connections[name]
+	}
+
+	return connections
+}
+
+// ForgetGone drops a connection to a node that is no longer registered. A
+//   node that is registered but didn't answer may only be busy, so it is
+//   kept, as it is when the registry can't be read.
+func (factory *ConnectionFactory) ForgetGone(conn *api.Connection) {
+	nodes, err := factory.registry.Nodes()
+	if err != nil {
+		fmt.Println("Reading the registry failed:", err)
+		return
+	}
+
+	for _, node := range nodes {
+		if node == conn.String() {
+			return
+		}
+	}
+
+	factory.Lock()
+	defer factory.Unlock()
+
+	delete(factory.connections, conn.String())
 }
 
 type Factory struct {
@@ -49,9 +103,13 @@ func (factory *Factory) Create() balancer.Status {
 	return &status
 }
 
+// A Status is what a reader node reports to the reader master. The
+//   channels it listens to against its maximum is the room the autoscaler
+//   places new channels by.
 type Status struct {
-	idleTime time.Duration
-	str      string
+	Channels    []string          `json:"channels"`
+	MaxChannels int               `json:"maxChannels"`
+	Jobs        []common.JobStats `json:"jobs"`
 }
 
 func (status *Status) GetIdleTime() time.Duration {
@@ -59,9 +117,14 @@ func (status *Status) GetIdleTime() time.Duration {
 }
 
 func (status *Status) String() string {
-	return time.Now().String()
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
 }
 
 func (status *Status) Update(z string) {
-
+	json.Unmarshal([]byte(z), status)
 }
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/magnesium38/balancer"
 	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
 )
 
 var (
@@ -49,29 +51,122 @@ func main() {
 func StartMaster(config *common.Config) {
 	fmt.Println("Starting the load balancer.")
 
-	// Create the load balancer.
+	// Listeners use TLS when the config has a certificate.
+	serverTLS, err := common.NewServerTLS(config.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// When calls are authenticated or encrypted, the load balancer only
+	//   listens locally and a gateway handles the calls made to its address.
+	address := config.Address.Reader
+	gateway, err := common.NewGateway(address, config.Client.Auth, serverTLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if gateway != nil {
+		address = gateway.Target()
+	}
+
+	// Keep the registry the load balancer reads. Readers that leave it are
+	//   forgotten by the factory too.
+	registry := common.NewFileRegistry(
+		config.Master.NodeRegistryPath,
+		time.Duration(config.Master.RegistryTTL)*time.Second)
+
+	// Create the load balancer. The factory is kept so the autoscaler can
+	//   see every reader the balancer knows about.
+	factory := NewConnectionFactory(NewStatusFactory(), config.Client, registry)
 	loadBalancer := balancer.NewLoadBalancer(
-		config.Address.Reader.Hostname,
-		config.Address.Reader.Port,
+		address.Hostname,
+		address.Port,
 		config.Master.NodeRegistryPath,
 		time.Duration(config.Master.NodeCheckFrequency)*time.Second,
-		NewConnectionFactory(NewStatusFactory()))
+		factory)
+
+	// Create the launcher that starts and stops nodes, and make it
+	//   available to operators.
+	launcher, err := common.NewLauncher(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	control := common.NewControlServer(config.Master.Control, config.Client.Auth, serverTLS)
+	err = control.Register(api.LauncherService, common.NewLauncherService(launcher))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Let draining readers hand their channels to the others.
+	err = api.RegisterChannels(control, NewChannelService(factory))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Let nodes that don't share the registry's filesystem register over
+	//   RPC.
+	err = control.Register(api.RegistryService, common.NewRegistryService(registry))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Let clients that can't call the load balancer themselves, like
+	//   JSON-RPC ones, hand it work and ask after its nodes.
+	proxy := api.NewMasterProxy(config.Address.Reader.String(), registry, config.Client,
+		config.Client.CallTimeout())
+	err = api.RegisterMasterProxy(control, proxy)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Queue up all the concurrent bits as jobs.
+	// Queue up all the concurrent bits as jobs. A signal stops them too,
+	//   along with any nodes the master started. The control server stays up
+	//   until they have, since they may deregister through it.
 	jobs := common.NewWorkGroup()
+	jobs.HandleSignals()
+	jobs.OnShutdown(func() error {
+		return common.StopNodes(launcher)
+	})
+	jobs.OnShutdown(control.Close)
 	jobs.Add(loadBalancer.MaintainNodes)
-	jobs.Add(loadBalancer.ListenAndServe)
+	if gateway != nil {
+		jobs.OnShutdown(gateway.Close)
+		jobs.Add(gateway.Serve(loadBalancer.ListenAndServe))
+		jobs.Add(gateway.ListenAndServe)
+	} else {
+		jobs.Add(loadBalancer.ListenAndServe)
+	}
+	jobs.Add(control.ListenAndServe)
+	if config.Master.JSONRPC.Port != 0 {
+		jobs.Add(func() error {
+			return control.ListenAndServeJSON(config.Master.JSONRPC)
+		})
+	}
+	jobs.AddContext(registry.Expire)
+
+	// Autoscaling is only on when there is a maximum number of nodes.
+	if config.Master.Scaling.MaxNodes > 0 {
+		autoscaler := NewAutoscaler(config, factory, launcher)
+		jobs.AddContext(autoscaler.Scale)
+	}
 
 	fmt.Println("Running.")
 
 	// Start the jobs.
 	jobs.Start()
 
-	// Wait for an error.
+	// Wait for an error or a signal.
 	fatalErr := jobs.Wait()
-
 	fmt.Println("Failing:", fatalErr)
-	log.Fatal(fatalErr)
+
+	// Give everything a chance to stop before exiting. A master stopped by
+	//   a signal exits cleanly, since it was meant to stop.
+	err = jobs.Shutdown(time.Duration(config.Master.ShutdownTimeout) * time.Second)
+	if common.Stopped(fatalErr) {
+		fmt.Println("Stopped:", err)
+		return
+	}
+	log.Fatal(err)
 }
 
 func StartNode(config *common.Config) {
@@ -79,52 +174,94 @@ func StartNode(config *common.Config) {
 
 	// Check the configuration.
 	host := config.Node.Hostname
-	port := config.Node.Port
-	registryPath := config.Master.NodeRegistryPath
 
-	// A port of 0 means that an open one will be assigned on listen.
-	//   The listening happens after registration though, so make
-	//   sure a port is defined if this is still 0 at startup.
-	var err error
-	if port == 0 {
-		port, err = common.GetOpenPort()
-		if err != nil {
-			log.Fatal(err)
-		}
+	// Bind the listener before anything else. A port of 0 means that an
+	//   open one is assigned here, and it is the port actually bound that
+	//   gets registered. It uses TLS when the config has a certificate.
+	serverTLS, err := common.NewServerTLS(config.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listener, port, err := common.Listen(host, config.Node.Port, serverTLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the registry the node announces itself in.
+	registry, err := api.NewRegistry(config)
+	if err != nil {
+		log.Fatal(err)
 	}
+	name := host + ":" + strconv.Itoa(port)
+	ttl := time.Duration(config.Master.RegistryTTL) * time.Second
 
-	// If the registry path is empty, the node cannot register.
-	if registryPath == "" {
-		log.Fatal(errors.New("The node registry path must not be empty."))
+	// A master that launched the node learns its address from here.
+	if err := common.ReportAddress(name); err != nil {
+		log.Fatal(err)
 	}
 
+	// Define the concurrent bits as a work group. A signal stops them too.
+	//   The worker reports on how the jobs are doing.
+	jobs := common.NewWorkGroup()
+	jobs.HandleSignals()
+
 	// Create the node worker.
-	worker, err := NewReader(config)
+	worker, err := NewReader(config, name, jobs)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Create the node itself.
-	node := balancer.NewNode(host, port, worker, registryPath)
+	node, err := common.NewNode(
+		listener,
+		worker,
+		time.Duration(config.Master.DrainTimeout)*time.Second,
+		config.Client.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Define the concurrent bits as a work group.
-	jobs := common.NewWorkGroup()
-	jobs.Add(worker.Work)
+	// The worker is restarted if it fails, like when IRC drops it.
+	jobs.Supervise("work", common.NewRestartPolicy(config.Master.Restart), func(context.Context) error {
+		return worker.Work()
+	})
 	jobs.Add(node.ListenAndServe)
+	jobs.AddContext(node.Drained)
+	jobs.AddContext(func(ctx context.Context) error {
+		// Register the node so it can be picked up by the load balancer,
+		//   and keep it from expiring.
+		return common.Heartbeat(ctx, registry, name, ttl)
+	})
+
+	// Shutdown things as gracefully as possible, in this order. The node
+	//   leaves the registry first so the load balancer stops handing it
+	//   work, then the worker stops, and only then does the node stop
+	//   listening.
+	jobs.OnShutdown(func() error {
+		return registry.Deregister(name)
+	})
+	jobs.OnShutdown(func() error {
+		worker.Shutdown()
+		return nil
+	})
+	jobs.OnShutdown(node.Close)
 
 	fmt.Println("Running.")
 
 	// Start working.
 	jobs.Start()
 
-	// Register the node so it can be picked up by the load balancer.
-	node.Register()
-
 	// Wait for things to die somehow.
 	fatalErr := jobs.Wait()
-	// Shutdown things as gracefully as possible.
-	worker.Shutdown()
-
 	fmt.Println("Failing:", fatalErr)
-	log.Fatal(fatalErr)
+
+	// Shutdown things as gracefully as possible before exiting. A node that
+	//   was drained or halted exits cleanly, since it was meant to stop.
+	err = jobs.Shutdown(time.Duration(config.Master.ShutdownTimeout) * time.Second)
+	if common.Stopped(fatalErr) {
+		fmt.Println("Stopped:", err)
+		return
+	}
+	log.Fatal(err)
 }
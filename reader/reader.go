@@ -1,34 +1,42 @@
 package main
 
 import (
-	"bufio"
 	"errors"
+	"fmt"
 	"net"
-	"net/rpc"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/sorcix/irc.v1"
 
 	"github.com/magnesium38/balancer"
 	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
 )
 
-// NewReader creates a new reader worker.
-func NewReader(config *common.Config) (*Reader, error) {
-	// Establish a connection to the App server's load balancer.
-	appServer, err := rpc.DialHTTP("tcp", config.Address.App.String())
-	if err != nil {
+// NewReader creates a new reader worker. The name identifies the node to
+//   the reader master when it hands off its channels, and the jobs are what
+//   it reports on in its status.
+func NewReader(config *common.Config, name string, jobs *common.WorkGroup) (*Reader, error) {
+	// Establish a connection to the App server's load balancer. It is
+	//   dialed again whenever the app master restarts.
+	appServer := api.NewMasterClient(config.Address.App.String(), config.Client)
+	if err := appServer.Connect(); err != nil {
 		return nil, err
 	}
 
 	worker := Reader{
 		config,
+		name,
 		&common.AtomicStringSlice{},
 		make(chan string),
 		make(chan string),
-		true,
+		atomic.Pointer[chan struct{}]{},
+		atomic.Pointer[common.IRCConn]{},
+		atomic.Bool{},
 		appServer,
+		jobs,
 	}
 
 	return &worker, nil
@@ -37,20 +45,32 @@ func NewReader(config *common.Config) (*Reader, error) {
 // A Reader is how the node listens to irc.
 type Reader struct {
 	config    *common.Config
+	name      string
 	channels  *common.AtomicStringSlice
 	toJoin    chan string
 	toPart    chan string
-	doWork    bool
-	appServer *rpc.Client
+	connected atomic.Pointer[chan struct{}]
+	conn      atomic.Pointer[common.IRCConn]
+	halted    atomic.Bool
+	appServer *api.MasterClient
+	jobs      *common.WorkGroup
 }
 
 // Join accepts the name of a channel and attempts to join it.
 func (worker *Reader) join(channel string) (string, error) {
+	// A reader that is full refuses the channel so it can go elsewhere.
+	limit := worker.config.Irc.MaxChannels
+	if limit > 0 && len(worker.channels.List()) >= limit {
+		return "", &balancer.InvalidWorkError{
+			Str: "Already listening on the maximum number of channels: " + channel,
+		}
+	}
+
 	// Add the channel to the channel slice to be kept track of.
 	worker.channels.Add(channel)
 
 	// Actually request to join the channel.
-	worker.toJoin <- channel
+	worker.request(worker.toJoin, channel)
 
 	// TO DO: This assumes that joining a channel cannot fail, which is false.
 	//   If a channel doesn't exist, joining would fail. This should be fixed,
@@ -76,53 +96,118 @@ func (worker *Reader) part(channel string) (string, error) {
 	// TO DO: This shouldn't fail like join, but it is blindly assuming that
 	//   it successfully left the channel. Another low priority fix.
 	worker.channels.Remove(channel)
-	worker.toPart <- channel
+	worker.request(worker.toPart, channel)
 	return "", nil
 }
 
-// Halt starts the shutdown of the worker.
+// A channel that is always closed, for when there is no connection.
+var disconnected = func() chan struct{} {
+	closed := make(chan struct{})
+	close(closed)
+	return closed
+}()
+
+// request hands a channel to join or part to the connection's channel
+//   manager. Without a connection, like while the work is waiting to be
+//   restarted, there is nobody to tell. The next connection joins whatever
+//   is in the channel list then, so nothing is lost.
+func (worker *Reader) request(to chan<- string, channel string) {
+	stop := disconnected
+	if connected := worker.connected.Load(); connected != nil {
+		stop = *connected
+	}
+
+	select {
+	case to <- channel:
+	case <-stop:
+	}
+}
+
+// Halt starts the shutdown of the worker. Work is most likely blocked on a
+//   read, so the connection is closed to end it.
 func (worker *Reader) halt() (string, error) {
-	worker.doWork = false
+	worker.halted.Store(true)
+	worker.disconnect()
 	return "", nil
 }
 
 // Work is the main function to actually read the irc connection.
 func (worker *Reader) Work() error {
-	// Perform initial connection to IRC.
-	ircConn, err := net.Dial("tcp", worker.config.Irc.ConnInfo)
+	// Readers only read, so they can log in with a profile that has no
+	//   token, like an anonymous one.
+	credentials, err := worker.config.Irc.Credentials(worker.config.Irc.ReaderProfile)
+	if err != nil {
+		return err
+	}
+
+	// Perform initial connection to IRC, over whatever the URL asks for.
+	ircConn, err := common.DialIRC(worker.config.Irc.ConnInfo, worker.config.Irc.Proxy, nil,
+		worker.config.Irc.DialTimeout())
 	if err != nil {
 		return err
 	}
 	defer ircConn.Close()
 
+	// Closing the connection is how a blocked read is ended on shutdown.
+	worker.conn.Store(&ircConn)
+
 	sleepDuration := time.Duration(worker.config.Irc.ReadFrequency) * time.Millisecond
 
-	reader := bufio.NewReader(ircConn)
+	// The channel manager belongs to this connection. If the work is
+	//   restarted, the next connection gets its own, and requests to join or
+	//   part only wait on it while it is around.
+	stop := make(chan struct{})
+	defer close(stop)
+	worker.connected.Store(&stop)
+
 	toWrite := worker.startWriter(ircConn)
-	worker.startChannelManager(toWrite)
+	worker.startChannelManager(toWrite, stop)
+
+	for _, line := range credentials.Login() {
+		toWrite <- line
+	}
 
-	toWrite <- "PASS " + worker.config.Irc.Password
-	toWrite <- "NICK " + worker.config.Irc.Nickname
+	// After a restart, listen to the same channels as before.
+	for _, channel := range worker.channels.List() {
+		msg := irc.Message{
+			Command: irc.JOIN,
+			Params:  []string{channel},
+		}
+		toWrite <- msg.String()
+	}
 
 	// Begin maintaining IRC connection.
-	for worker.doWork {
-		line, err := reader.ReadString('\n')
+	for !worker.halted.Load() {
+		line, err := ircConn.ReadLine()
+		if err != nil && worker.halted.Load() {
+			break
+		}
 		if err != nil {
-			// Most likely just not enough to read. Sleep it off.
-			time.Sleep(sleepDuration)
-			continue
+			// A read that timed out only means there was not enough to
+			//   read, so sleep it off.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(sleepDuration)
+				continue
+			}
+
+			// Anything else means the connection has died, so let the
+			//   work be restarted.
+			return fmt.Errorf("The connection to IRC was lost: %w", err)
 		}
 
 		go worker.process(line, toWrite)
 	}
 
-	return errors.New("The worker was instructed to stop.")
+	return common.ErrHalted
 }
 
-func (worker *Reader) startChannelManager(toWrite chan<- string) {
+func (worker *Reader) startChannelManager(toWrite chan<- string, stop <-chan struct{}) {
 	go func() {
 		for {
 			select {
+			case <-stop:
+				return
 			case channel := <-worker.toJoin:
 				msg := irc.Message{
 					Command: irc.JOIN,
@@ -140,13 +225,12 @@ func (worker *Reader) startChannelManager(toWrite chan<- string) {
 	}()
 }
 
-func (worker *Reader) startWriter(conn net.Conn) chan<- string {
+func (worker *Reader) startWriter(conn common.IRCConn) chan<- string {
 	// Create the channel
 	write := make(chan string)
 
 	// Create a concurrent function to write to the connection from the channel.
-	go func(conn net.Conn, toWrite chan string) {
-		writer := bufio.NewWriter(conn)
+	go func(conn common.IRCConn, toWrite chan string) {
 		for {
 			line, okay := <-toWrite
 			if !okay {
@@ -158,8 +242,7 @@ func (worker *Reader) startWriter(conn net.Conn) chan<- string {
 				continue
 			}
 
-			writer.WriteString(line + "\r\n")
-			writer.Flush()
+			conn.WriteLine(line)
 		}
 	}(conn, write)
 
@@ -168,9 +251,7 @@ func (worker *Reader) startWriter(conn net.Conn) chan<- string {
 
 func (worker *Reader) process(line string, toWrite chan<- string) {
 	// Pass the line onto the app server's load balancer.
-	var reply string
-	// TO DO: Check what the RPC name to call.
-	err := worker.appServer.Call("Master.Work", line, &reply)
+	reply, err := worker.appServer.Work(line)
 	if err != nil {
 		// If there's an error, it's something the app server returned.
 		//   Should be safe to just log and ignore.
@@ -179,6 +260,30 @@ func (worker *Reader) process(line string, toWrite chan<- string) {
 	toWrite <- reply
 }
 
+// Drain hands the reader's channels to the rest of the tier before the
+//   node stops. The reader master joins them elsewhere first, so nothing is
+//   missed, and only then does this reader part them.
+func (worker *Reader) Drain(deadline time.Time) error {
+	channels := worker.channels.List()
+
+	if len(channels) > 0 {
+		master := api.NewChannelsClient(worker.config.Master.Control.String(), worker.config.Client)
+		defer master.Close()
+
+		_, err := master.Move(api.MoveArgs{Node: worker.name, Channels: channels}, deadline)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, channel := range channels {
+		worker.part(channel)
+	}
+
+	worker.halt()
+	return nil
+}
+
 // Shutdown starts as graceful of a shutdown of the worker as possible.
 func (worker *Reader) Shutdown() {
 	// Stop reading IRC.
@@ -186,6 +291,14 @@ func (worker *Reader) Shutdown() {
 	worker.appServer.Close()
 }
 
+// disconnect closes the IRC connection, which ends a read Work is blocked
+//   on.
+func (worker *Reader) disconnect() {
+	if conn := worker.conn.Load(); conn != nil {
+		(*conn).Close()
+	}
+}
+
 // Do instructs the worker to complete some form of load balanced work.
 func (worker *Reader) Do(work string) (string, error) {
 	// A reader's `work` is leaving or joining a channel.
@@ -230,7 +343,12 @@ func (worker *Reader) Do(work string) (string, error) {
 	}
 }
 
+// Status reports which channels the reader is listening to, and how many
+//   it is allowed to.
 func (worker *Reader) Status(requestTime time.Time) balancer.Status {
-	// TO DO: revisit statuses.
-	return common.NewStatus()
+	return &Status{
+		Channels:    worker.channels.List(),
+		MaxChannels: worker.config.Irc.MaxChannels,
+		Jobs:        worker.jobs.Stats(),
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+func TestHaltEndsWork(t *testing.T) {
+	// An IRC server that takes the login and never says anything back.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	config := &common.Config{}
+	config.Irc.ConnInfo = listener.Addr().String()
+	config.Irc.Nickname = "reader"
+
+	worker := &Reader{
+		config:   config,
+		channels: &common.AtomicStringSlice{},
+		toJoin:   make(chan string),
+		toPart:   make(chan string),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Work()
+	}()
+
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("The reader never connected.")
+	}
+
+	// Work is now blocked reading a server with nothing to say.
+	if _, err := worker.Do("HALT"); err != nil {
+		t.Error(err, "HALT should not fail.")
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, common.ErrHalted) {
+			t.Error(err, "Work should end as halted.")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("HALT should end a read that would otherwise never return.")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// NewAutoscaler creates the autoscaler for the writer tier. It reads the
+//   reports writer nodes give the coordinator and asks the launcher for
+//   more or fewer nodes.
+func NewAutoscaler(config *common.Config, coordinator *Coordinator, launcher common.Launcher) *Autoscaler {
+	scaling := config.Master.Scaling
+
+	// With a pool of accounts, a node past the size of the pool would have
+	//   none to log in as.
+	if pool := len(config.Irc.WriterAccounts); pool > 0 && scaling.MaxNodes > pool {
+		scaling.MaxNodes = pool
+	}
+
+	return &Autoscaler{
+		common.NewAutoscaler(scaling, launcher, "writer"),
+		scaling,
+		coordinator,
+	}
+}
+
+// An Autoscaler adds writer nodes when they send close to their target
+//   number of messages or their queues back up, and removes them when they
+//   sit mostly idle.
+type Autoscaler struct {
+	*common.Autoscaler
+	scaling     common.ScalingConfig
+	coordinator *Coordinator
+}
+
+// Scale checks on the writer nodes at the configured frequency until
+//   ctx is cancelled.
+func (autoscaler *Autoscaler) Scale(ctx context.Context) error {
+	return autoscaler.Run(ctx, autoscaler.check)
+}
+
+// check compares the latest reports with the targets and scales if needed.
+func (autoscaler *Autoscaler) check() error {
+	reports := autoscaler.coordinator.Reports()
+	nodes := len(reports)
+
+	over, under := false, false
+	if nodes > 0 {
+		messages := 0
+		var wait time.Duration
+		for _, status := range reports {
+			messages += status.MessagesSent
+			wait += status.QueueWait
+		}
+		messages /= nodes
+		wait /= time.Duration(nodes)
+
+		target := autoscaler.scaling.TargetMessages
+		maxWait := time.Duration(autoscaler.scaling.MaxQueueWait) * time.Millisecond
+
+		// The rate limit is per account, so a node logged in as an account
+		//   that is already in use only takes a share of its limit from the
+		//   others. Busy nodes only call for another when it gets an account
+		//   of its own, otherwise the waiting is the rate limit at work.
+		over = (messages > target || wait > maxWait) &&
+			autoscaler.coordinator.FreeAccounts() > 0
+		under = float64(messages) < float64(target)*autoscaler.scaling.ScaleDownRatio &&
+			wait < maxWait/2
+	}
+
+	switch autoscaler.Decide(nodes, over, under) {
+	case common.ScaleUp:
+		return autoscaler.Launch()
+	case common.ScaleDown:
+		return autoscaler.stop(reports)
+	}
+
+	return nil
+}
+
+// stop removes the writer node that has been sending the least. Nodes the
+//   launcher manages are preferred, since those are the ones it can stop.
+func (autoscaler *Autoscaler) stop(reports map[string]api.WriterStatus) error {
+	if managed := autoscaler.Launcher().Nodes(); len(managed) > 0 {
+		candidates := make(map[string]api.WriterStatus)
+		for _, node := range managed {
+			if status, ok := reports[node]; ok {
+				candidates[node] = status
+			}
+		}
+
+		if len(candidates) > 0 {
+			reports = candidates
+		}
+	}
+
+	quietest := ""
+	least := 0
+	for node, status := range reports {
+		if quietest == "" || status.MessagesSent < least {
+			quietest = node
+			least = status.MessagesSent
+		}
+	}
+
+	fmt.Println("Stopping a writer node:", quietest)
+	return autoscaler.Launcher().Stop(quietest)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// A fakeLauncher keeps track of the nodes it was asked to start and stop.
+type fakeLauncher struct {
+	nodes   []string
+	stopped []string
+}
+
+func (launcher *fakeLauncher) Launch() (string, error) {
+	node := "localhost:" + strconv.Itoa(9000+len(launcher.nodes))
+	launcher.nodes = append(launcher.nodes, node)
+	return node, nil
+}
+
+func (launcher *fakeLauncher) Stop(node string) error {
+	launcher.stopped = append(launcher.stopped, node)
+	return nil
+}
+
+func (launcher *fakeLauncher) Nodes() []string {
+	return launcher.nodes
+}
+
+// newTestAutoscaler returns an autoscaler that acts on the first check,
+//   over a pool of the given accounts.
+func newTestAutoscaler(accounts ...string) (*Autoscaler, *Coordinator, *fakeLauncher) {
+	config := testConfig()
+	config.Irc.WriterAccounts = accounts
+	config.Master.Scaling.MinNodes = 1
+	config.Master.Scaling.MaxNodes = 5
+	config.Master.Scaling.Checks = 1
+	config.Master.Scaling.ScaleDownRatio = 0.5
+	config.Master.Scaling.TargetMessages = 10
+	config.Master.Scaling.MaxQueueWait = 1000
+
+	coordinator := NewCoordinator(config)
+	launcher := &fakeLauncher{}
+
+	return NewAutoscaler(config, coordinator, launcher), coordinator, launcher
+}
+
+// reportSent tells the coordinator how a node logged in as account is
+//   doing.
+func reportSent(coordinator *Coordinator, node string, account string, sent int, wait time.Duration) {
+	var reply bool
+	status := api.WriterStatus{MessagesSent: sent, QueueWait: wait}
+	coordinator.Report(api.ReportArgs{Node: node, Account: account, Status: status}, &reply)
+}
+
+func TestAutoscalerScaleUp(t *testing.T) {
+	autoscaler, coordinator, launcher := newTestAutoscaler("one", "two")
+	reportSent(coordinator, "localhost:1", "one", 15, 0)
+
+	// A free account means another node adds to what the tier can send.
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.nodes) != 1 {
+		t.Error(launcher.nodes, "A busy tier with a free account should grow.")
+	}
+
+	// With every account leased, the waiting is the rate limit at work.
+	reportSent(coordinator, "localhost:2", "two", 15, 2*time.Second)
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.nodes) != 1 {
+		t.Error(launcher.nodes, "Without a free account the tier should not grow.")
+	}
+}
+
+func TestAutoscalerSharedAccount(t *testing.T) {
+	// Without a pool, every node shares one account's limit.
+	autoscaler, coordinator, launcher := newTestAutoscaler()
+	reportSent(coordinator, "localhost:1", "", 20, 5*time.Second)
+
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.nodes) != 0 {
+		t.Error(launcher.nodes, "Nodes sharing an account should not be added.")
+	}
+}
+
+func TestAutoscalerBounds(t *testing.T) {
+	// Below the minimum, a node is added whatever the reports say.
+	autoscaler, _, launcher := newTestAutoscaler()
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.nodes) != 1 {
+		t.Error(launcher.nodes, "The tier should be brought up to its minimum.")
+	}
+}
+
+func TestAutoscalerScaleDown(t *testing.T) {
+	autoscaler, coordinator, launcher := newTestAutoscaler("one", "two")
+	launcher.Launch()
+	reportSent(coordinator, "localhost:1", "one", 4, 0)
+	reportSent(coordinator, launcher.nodes[0], "two", 1, 0)
+
+	// The quietest node the launcher manages is the one to go.
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.stopped) != 1 || launcher.stopped[0] != launcher.nodes[0] {
+		t.Error(launcher.stopped, "The quietest managed node should have been stopped.")
+	}
+
+	// At the minimum, nothing more is stopped.
+	autoscaler, coordinator, launcher = newTestAutoscaler("one")
+	reportSent(coordinator, "localhost:1", "one", 0, 0)
+	if err := autoscaler.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(launcher.stopped) != 0 {
+		t.Error(launcher.stopped, "The tier should not go below its minimum.")
+	}
+}
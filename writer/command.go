@@ -0,0 +1,296 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// Twitch caps a timeout at two weeks.
+const maxTimeoutSeconds = 1209600
+
+// Twitch runs chat text that starts with one of these as a chat command.
+//   Chat verbs put an invisible character Twitch does not strip in front of
+//   such text, so commands only go out through RAW and the moderation verbs.
+const (
+	commandPrefixes = "/."
+	commandEscape   = "\U000E0000"
+)
+
+// maxChatLength is how long a piece of a split chat message can be, with
+//   room left for an escape and a duplicate variation.
+var maxChatLength = common.MaxMessageLength -
+	len([]rune(commandEscape)) - len([]rune(duplicateVariation))
+
+var (
+	channelPattern = regexp.MustCompile(`^#[a-z0-9_]{1,25}$`)
+	userPattern    = regexp.MustCompile(`^[A-Za-z0-9_]{1,25}$`)
+	msgIDPattern   = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// A command is a validated piece of writer work. The app tier describes
+//   what it wants sent and the writer builds the IRC line, rather than the
+//   app tier building raw lines by hand. The grammar is:
+//
+//     SAY <#channel> <text>
+//     ACTION <#channel> <text>
+//     REPLY <#channel> <msg-id> <text>
+//     WHISPER <user> <text>
+//     TIMEOUT <#channel> <user> <seconds> [reason]
+//     BAN <#channel> <user> [reason]
+//     UNBAN <#channel> <user>
+//     DELETE <#channel> <msg-id>
+//     CLEAR <#channel>
+//     RAW <line>
+type command struct {
+	name    string
+	channel string
+	target  string
+	seconds int
+	text    string
+}
+
+// parseCommand validates a piece of work and turns it into a command.
+func parseCommand(work string) (*command, error) {
+	name, rest := cut(work)
+	cmd := command{name: name}
+
+	var err error
+	switch name {
+	case "SAY", "ACTION":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		cmd.text = rest
+		if err == nil && cmd.text == "" {
+			err = invalidCommand("No text was given", work)
+		}
+	case "REPLY":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil {
+			cmd.target, rest, err = cutMatch(rest, msgIDPattern, "message id", work)
+		}
+		cmd.text = rest
+		if err == nil && cmd.text == "" {
+			err = invalidCommand("No text was given", work)
+		}
+	case "WHISPER":
+		// Whispers are sent through Twitch's special #jtv channel.
+		cmd.channel = "#jtv"
+		cmd.target, rest, err = cutMatch(rest, userPattern, "user", work)
+		cmd.text = rest
+		if err == nil && cmd.text == "" {
+			err = invalidCommand("No text was given", work)
+		}
+	case "TIMEOUT":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil {
+			cmd.target, rest, err = cutMatch(rest, userPattern, "user", work)
+		}
+		if err == nil {
+			var seconds string
+			seconds, rest = cut(rest)
+			cmd.seconds, err = strconv.Atoi(seconds)
+			if err != nil || cmd.seconds < 1 || cmd.seconds > maxTimeoutSeconds {
+				err = invalidCommand("Timeout length was not valid", work)
+			}
+		}
+		cmd.text = rest
+	case "BAN":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil {
+			cmd.target, rest, err = cutMatch(rest, userPattern, "user", work)
+		}
+		cmd.text = rest
+	case "UNBAN":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil {
+			cmd.target, rest, err = cutMatch(rest, userPattern, "user", work)
+		}
+		if err == nil && rest != "" {
+			err = invalidCommand("Unexpected text at the end", work)
+		}
+	case "DELETE":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil {
+			cmd.target, rest, err = cutMatch(rest, msgIDPattern, "message id", work)
+		}
+		if err == nil && rest != "" {
+			err = invalidCommand("Unexpected text at the end", work)
+		}
+	case "CLEAR":
+		cmd.channel, rest, err = cutChannel(rest, work)
+		if err == nil && rest != "" {
+			err = invalidCommand("Unexpected text at the end", work)
+		}
+	case "RAW":
+		// Raw lines are passed through untouched, so whoever sends them is
+		//   responsible for them being valid IRC.
+		cmd.text = rest
+		if cmd.text == "" {
+			err = invalidCommand("No line was given", work)
+		}
+	default:
+		err = invalidCommand("Work given did not have an accepted command", work)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmd, nil
+}
+
+// pieces cleans up the command's text and splits chat messages that are
+//   too long into several commands, in the order they should be sent. A
+//   piece that Twitch would run as a chat command is escaped, and the
+//   pieces leave room for a duplicate variation.
+func (cmd *command) pieces() ([]*command, error) {
+	// Raw lines are left alone, the line break check on all work is the
+	//   only protection they get.
+	if cmd.name == "RAW" {
+		return []*command{cmd}, nil
+	}
+
+	text, err := common.SanitizeText(cmd.text)
+	if err != nil {
+		return nil, invalidCommand(err.Error(), cmd.text)
+	}
+
+	if cmd.isChat() {
+		var pieces []*command
+		for _, piece := range common.SplitText(text, maxChatLength) {
+			if strings.ContainsAny(piece[:1], commandPrefixes) {
+				piece = commandEscape + piece
+			}
+
+			part := *cmd
+			part.text = piece
+			pieces = append(pieces, &part)
+		}
+
+		if len(pieces) == 0 {
+			return nil, invalidCommand("No text was left after cleaning", cmd.text)
+		}
+
+		return pieces, nil
+	}
+
+	if len([]rune(text)) > common.MaxMessageLength {
+		return nil, invalidCommand("Reason was too long", text)
+	}
+
+	part := *cmd
+	part.text = text
+	return []*command{&part}, nil
+}
+
+// priority returns how urgently the command should be written.
+func (cmd *command) priority() priority {
+	switch cmd.name {
+	case "TIMEOUT", "BAN", "UNBAN", "DELETE", "CLEAR":
+		return priorityModeration
+	case "REPLY", "WHISPER":
+		return priorityReply
+	case "RAW":
+		priority, _ := classify(cmd.text)
+		return priority
+	default:
+		return priorityAnnouncement
+	}
+}
+
+// isChat reports whether the command sends a chat message, as opposed to
+//   moderating or passing through a raw line.
+func (cmd *command) isChat() bool {
+	switch cmd.name {
+	case "SAY", "ACTION", "REPLY", "WHISPER":
+		return true
+	default:
+		return false
+	}
+}
+
+// chatText returns the text of a chat message as Twitch will see it.
+func (cmd *command) chatText() string {
+	switch cmd.name {
+	case "ACTION":
+		return "\x01ACTION " + cmd.text + "\x01"
+	case "WHISPER":
+		return "/w " + cmd.target + " " + cmd.text
+	default:
+		return cmd.text
+	}
+}
+
+// line builds the IRC line for the command.
+func (cmd *command) line() string {
+	switch cmd.name {
+	case "SAY", "ACTION", "WHISPER":
+		return privmsg(cmd.channel, cmd.chatText())
+	case "REPLY":
+		return "@reply-parent-msg-id=" + cmd.target + " " +
+			privmsg(cmd.channel, cmd.chatText())
+	case "TIMEOUT":
+		return privmsg(cmd.channel, strings.TrimSpace(
+			"/timeout "+cmd.target+" "+strconv.Itoa(cmd.seconds)+" "+cmd.text))
+	case "BAN":
+		return privmsg(cmd.channel, strings.TrimSpace("/ban "+cmd.target+" "+cmd.text))
+	case "UNBAN":
+		return privmsg(cmd.channel, "/unban "+cmd.target)
+	case "DELETE":
+		return privmsg(cmd.channel, "/delete "+cmd.target)
+	case "CLEAR":
+		return privmsg(cmd.channel, "/clear")
+	default:
+		return cmd.text
+	}
+}
+
+// queueChannel returns the channel the command is queued under.
+func (cmd *command) queueChannel() string {
+	if cmd.name == "RAW" {
+		_, channel := classify(cmd.text)
+		return channel
+	}
+
+	return cmd.channel
+}
+
+func privmsg(channel string, text string) string {
+	return "PRIVMSG " + channel + " :" + text
+}
+
+// cut splits off the first word of s.
+func cut(s string) (string, string) {
+	s = strings.TrimLeft(s, " ")
+
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
+// cutChannel splits off the first word of s as a channel name.
+func cutChannel(s string, work string) (string, string, error) {
+	return cutMatch(s, channelPattern, "channel", work)
+}
+
+// cutMatch splits off the first word of s, which has to match pattern.
+func cutMatch(s string, pattern *regexp.Regexp, what string, work string) (string, string, error) {
+	word, rest := cut(s)
+	if !pattern.MatchString(word) {
+		return "", "", invalidCommand("Not a valid "+what+" '"+word+"'", work)
+	}
+
+	return word, rest, nil
+}
+
+func invalidCommand(reason string, work string) error {
+	return &balancer.InvalidWorkError{
+		Str: reason + ": " + work,
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		work     string
+		line     string
+		priority priority
+	}{
+		{"SAY #a hello there", "PRIVMSG #a :hello there", priorityAnnouncement},
+		{"ACTION #a waves", "PRIVMSG #a :\x01ACTION waves\x01", priorityAnnouncement},
+		{"REPLY #a abc-123 hi", "@reply-parent-msg-id=abc-123 PRIVMSG #a :hi", priorityReply},
+		{"WHISPER someone psst", "PRIVMSG #jtv :/w someone psst", priorityReply},
+		{"TIMEOUT #a user 600", "PRIVMSG #a :/timeout user 600", priorityModeration},
+		{"TIMEOUT #a user 600 too loud", "PRIVMSG #a :/timeout user 600 too loud", priorityModeration},
+		{"BAN #a user", "PRIVMSG #a :/ban user", priorityModeration},
+		{"BAN #a user spam bot", "PRIVMSG #a :/ban user spam bot", priorityModeration},
+		{"UNBAN #a user", "PRIVMSG #a :/unban user", priorityModeration},
+		{"DELETE #a abc-123", "PRIVMSG #a :/delete abc-123", priorityModeration},
+		{"CLEAR #a", "PRIVMSG #a :/clear", priorityModeration},
+		{"RAW PRIVMSG #a :raw", "PRIVMSG #a :raw", priorityAnnouncement},
+		{"RAW JOIN #a", "JOIN #a", priorityProtocol},
+	}
+
+	for _, test := range tests {
+		cmd, err := parseCommand(test.work)
+		if err != nil {
+			t.Error(test.work, err, "Valid work was rejected.")
+			continue
+		}
+
+		if line := cmd.line(); line != test.line {
+			t.Error(test.work, line, "The wrong line was built.")
+		}
+
+		if cmd.priority() != test.priority {
+			t.Error(test.work, cmd.priority(), "The command has the wrong priority.")
+		}
+	}
+}
+
+func TestParseCommandInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"SHOUT #a hello",
+		"SAY",
+		"SAY #a",
+		"SAY a hello",
+		"SAY #A hello",
+		"REPLY #a hi",
+		"REPLY #a bad_id! hi",
+		"WHISPER some.one hi",
+		"WHISPER someone",
+		"TIMEOUT #a user",
+		"TIMEOUT #a user 0",
+		"TIMEOUT #a user 1209601",
+		"TIMEOUT #a user soon",
+		"BAN #a",
+		"UNBAN #a user extra",
+		"DELETE #a",
+		"DELETE #a abc extra",
+		"CLEAR #a extra",
+		"RAW",
+	}
+
+	for _, work := range tests {
+		_, err := parseCommand(work)
+		if _, ok := err.(*balancer.InvalidWorkError); !ok {
+			t.Error(work, err, "Invalid work should be rejected as such.")
+		}
+	}
+}
+
+func TestCommandPieces(t *testing.T) {
+	long := strings.Repeat("word ", 250)
+
+	tests := []struct {
+		work   string
+		pieces int
+		valid  bool
+	}{
+		{"SAY #a hello", 1, true},
+		{"SAY #a " + long, 3, true},
+		{"WHISPER someone " + long, 3, true},
+		{"SAY #a \x01\x02", 0, false},
+		{"BAN #a user " + strings.Repeat("r", 501), 0, false},
+		{"BAN #a user reason", 1, true},
+		{"RAW PRIVMSG #a :" + long, 1, true},
+	}
+
+	for _, test := range tests {
+		cmd, err := parseCommand(test.work)
+		if err != nil {
+			t.Fatal(test.work, err)
+		}
+
+		pieces, err := cmd.pieces()
+		if (err == nil) != test.valid {
+			t.Error(cmd.name, err, "The text was not checked right.")
+			continue
+		}
+
+		if len(pieces) != test.pieces {
+			t.Error(cmd.name, len(pieces), "The text was split into the wrong number of pieces.")
+		}
+
+		// Pieces of a split message keep what they were sent to.
+		for _, piece := range pieces {
+			if piece.name != cmd.name || piece.channel != cmd.channel || piece.target != cmd.target {
+				t.Error(cmd.name, piece, "A piece lost where it was going.")
+			}
+		}
+	}
+}
+
+func TestChatCommandsEscaped(t *testing.T) {
+	tests := []struct {
+		work string
+		line string
+	}{
+		{"SAY #c /ban victim", "PRIVMSG #c :" + commandEscape + "/ban victim"},
+		{"SAY #c .ban victim", "PRIVMSG #c :" + commandEscape + ".ban victim"},
+		{"ACTION #c /clear", "PRIVMSG #c :\x01ACTION " + commandEscape + "/clear\x01"},
+		{"REPLY #c abc /timeout victim 10", "@reply-parent-msg-id=abc PRIVMSG #c :" + commandEscape + "/timeout victim 10"},
+		{"SAY #c hi /ban victim", "PRIVMSG #c :hi /ban victim"},
+		{"BAN #c victim", "PRIVMSG #c :/ban victim"},
+		{"RAW PRIVMSG #c :/ban victim", "PRIVMSG #c :/ban victim"},
+	}
+
+	for _, test := range tests {
+		cmd, err := parseCommand(test.work)
+		if err != nil {
+			t.Fatal(test.work, err)
+		}
+
+		pieces, err := cmd.pieces()
+		if err != nil || len(pieces) != 1 {
+			t.Fatal(test.work, pieces, err)
+		}
+
+		if line := pieces[0].line(); line != test.line {
+			t.Error(test.work, line, "Only RAW and the moderation verbs should send commands.")
+		}
+	}
+
+	// A piece of a split message can start with a command too.
+	cmd, err := parseCommand("SAY #c " + strings.Repeat("a", maxChatLength) + " /ban victim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pieces, err := cmd.pieces()
+	if err != nil || len(pieces) != 2 || pieces[1].text != commandEscape+"/ban victim" {
+		t.Error(pieces, err, "The second piece should have been escaped.")
+	}
+
+	for _, piece := range pieces {
+		if len([]rune(piece.text+duplicateVariation)) > common.MaxMessageLength {
+			t.Error(len([]rune(piece.text)), "An escaped piece has no room left for a variation.")
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// NewCoordinator creates the service the writer master uses to lease the
+//   writer accounts to nodes, and to split each account's message limit
+//   between the nodes logged in as it.
+func NewCoordinator(config *common.Config) *Coordinator {
+	window := time.Duration(config.Irc.MessageWindow) * time.Second
+
+	// The empty account is the IRC config's own, which nodes use when
+	//   there is no pool of accounts.
+	limits := make(map[string]*accountLimits)
+	for _, account := range append([]string{""}, config.Irc.WriterAccounts...) {
+		limits[account] = &accountLimits{
+			global:    common.NewRateLimiter(config.Irc.MessageLimit, window),
+			moderator: common.NewRateLimiter(config.Irc.ModeratorMessageLimit, window),
+		}
+	}
+
+	return &Coordinator{
+		limit:    config.Irc.MessageLimit,
+		window:   window,
+		accounts: config.Irc.WriterAccounts,
+		limits:   limits,
+		leases:   make(map[string]string),
+		nodes:    make(map[string]*nodeShare),
+	}
+}
+
+// A Coordinator hands out send tokens to writer nodes. Twitch counts the
+//   messages of an account together, so the nodes logged in as the same
+//   account are each given an even share of its limit, and the shares are
+//   rebalanced as nodes come and go. Messages to channels where the account
+//   is a moderator have a higher limit of their own, which every message
+//   counts towards. With a pool of writer accounts, each node leases one
+//   to itself and has its whole limit.
+type Coordinator struct {
+	sync.Mutex
+	limit    int
+	window   time.Duration
+	accounts []string
+	limits   map[string]*accountLimits
+	leases   map[string]string
+	nodes    map[string]*nodeShare
+}
+
+type accountLimits struct {
+	global    *common.RateLimiter
+	moderator *common.RateLimiter
+}
+
+type nodeShare struct {
+	account  string
+	limiter  *common.RateLimiter
+	lastSeen time.Time
+	report   *api.WriterStatus
+}
+
+// Acquire requests tokens for a node, registering the node if it is new.
+func (coordinator *Coordinator) Acquire(args api.AcquireArgs, reply *api.AcquireReply) error {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	share := coordinator.touch(args.Node)
+	coordinator.claim(args.Node, share, args.Account)
+
+	reply.Share = share.limiter.Limit()
+
+	// Every limiter that applies has to have room. Only take from them once
+	//   they all do.
+	account := coordinator.limits[share.account]
+	limiters := []*common.RateLimiter{account.moderator}
+	if !args.Moderator {
+		limiters = append(limiters, share.limiter, account.global)
+	}
+
+	var wait time.Duration
+	for _, limiter := range limiters {
+		if limiterWait := limiter.Wait(args.Count); limiterWait > wait {
+			wait = limiterWait
+		}
+	}
+
+	if wait == 0 {
+		for _, limiter := range limiters {
+			limiter.Take(args.Count)
+		}
+	}
+
+	reply.Wait = wait
+
+	return nil
+}
+
+// Report stores the latest status of a node. Nodes report regularly even
+//   when they aren't sending, which keeps their share from expiring.
+func (coordinator *Coordinator) Report(args api.ReportArgs, reply *bool) error {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	status := args.Status
+	share := coordinator.touch(args.Node)
+	coordinator.claim(args.Node, share, args.Account)
+	share.report = &status
+
+	*reply = true
+	return nil
+}
+
+// Reports returns the latest status of every node that has reported.
+func (coordinator *Coordinator) Reports() map[string]api.WriterStatus {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	reports := make(map[string]api.WriterStatus)
+	for node, share := range coordinator.nodes {
+		if share.report != nil {
+			reports[node] = *share.report
+		}
+	}
+
+	return reports
+}
+
+// Release removes a node so its share and its account can go to the
+//   others.
+func (coordinator *Coordinator) Release(node string, reply *bool) error {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	if _, ok := coordinator.nodes[node]; ok {
+		coordinator.remove(node)
+		coordinator.rebalance()
+
+		fmt.Println("Writer node left:", node)
+	}
+
+	*reply = true
+	return nil
+}
+
+// Lease gives a node one of the writer accounts to log in as, or the one it
+//   already has if it asked before. Without a pool of accounts, every node
+//   logs in as the IRC config's own and the account is empty.
+func (coordinator *Coordinator) Lease(node string, account *string) error {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	share := coordinator.touch(node)
+	if len(coordinator.accounts) == 0 || share.account != "" {
+		*account = share.account
+		return nil
+	}
+
+	for _, candidate := range coordinator.accounts {
+		if _, leased := coordinator.leases[candidate]; leased {
+			continue
+		}
+
+		coordinator.leases[candidate] = node
+		share.account = candidate
+		coordinator.rebalance()
+
+		fmt.Println("Writer node leased an account:", node, candidate)
+
+		*account = candidate
+		return nil
+	}
+
+	return errors.New("Every writer account is already leased.")
+}
+
+// FreeAccounts returns how many of the writer accounts no node has leased.
+//   Without a pool of accounts there are none, since every node shares the
+//   IRC config's own.
+func (coordinator *Coordinator) FreeAccounts() int {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	return len(coordinator.accounts) - len(coordinator.leases)
+}
+
+// Holder replies with the node that leased an account.
+func (coordinator *Coordinator) Holder(account string, node *string) error {
+	coordinator.Lock()
+	defer coordinator.Unlock()
+
+	holder, ok := coordinator.leases[account]
+	if !ok {
+		return errors.New("No writer node has leased the account: " + account)
+	}
+
+	*node = holder
+	return nil
+}
+
+// MaintainShares drops nodes that have not asked for tokens in a while.
+//   A node that died never calls Release, so without this its share would
+//   be lost for good. It stops once ctx is cancelled.
+func (coordinator *Coordinator) MaintainShares(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(coordinator.window):
+		}
+
+		coordinator.Lock()
+		cutoff := time.Now().Add(-2 * coordinator.window)
+		removed := false
+		for node, share := range coordinator.nodes {
+			if share.lastSeen.Before(cutoff) {
+				coordinator.remove(node)
+				removed = true
+
+				fmt.Println("Writer node expired:", node)
+			}
+		}
+
+		if removed {
+			coordinator.rebalance()
+		}
+		coordinator.Unlock()
+	}
+}
+
+// touch marks a node as seen, registering it if it is new. The lock must
+//   be held by the caller.
+func (coordinator *Coordinator) touch(node string) *nodeShare {
+	share, ok := coordinator.nodes[node]
+	if !ok {
+		share = &nodeShare{
+			limiter: common.NewRateLimiter(0, coordinator.window),
+		}
+		coordinator.nodes[node] = share
+		coordinator.rebalance()
+
+		fmt.Println("Writer node joined:", node)
+	}
+	share.lastSeen = time.Now()
+
+	return share
+}
+
+// claim gives a node back the account it says it leased. The coordinator
+//   only keeps leases in memory, so they are lost when the writer master
+//   restarts or the node's share expires, while the node is still logged
+//   in as the account. An account since leased to another node stays with
+//   that node. The lock must be held by the caller.
+func (coordinator *Coordinator) claim(node string, share *nodeShare, account string) {
+	if account == "" || share.account != "" {
+		return
+	}
+
+	if _, ok := coordinator.limits[account]; !ok {
+		fmt.Println("Writer node claimed an unknown account:", node, account)
+		return
+	}
+
+	if holder, leased := coordinator.leases[account]; leased {
+		fmt.Println("Writer node claimed an account leased to another:", node, account, holder)
+		return
+	}
+
+	coordinator.leases[account] = node
+	share.account = account
+	coordinator.rebalance()
+
+	fmt.Println("Writer node took its account back:", node, account)
+}
+
+// remove forgets a node, and frees the account it leased. The lock must be
+//   held by the caller.
+func (coordinator *Coordinator) remove(node string) {
+	if share, ok := coordinator.nodes[node]; ok && share.account != "" {
+		delete(coordinator.leases, share.account)
+	}
+
+	delete(coordinator.nodes, node)
+}
+
+// rebalance splits each account's limit evenly between the nodes logged in
+//   as it, giving any remainder to the first nodes by name. With more nodes
+//   than the limit, every node still gets a share of 1, or some could never
+//   send at all. The shares then add up to more than the limit, which the
+//   account's own limiter still holds them to. The lock must be held by the
+//   caller.
+func (coordinator *Coordinator) rebalance() {
+	accounts := make(map[string][]string)
+	for name, share := range coordinator.nodes {
+		accounts[share.account] = append(accounts[share.account], name)
+	}
+
+	for _, names := range accounts {
+		sort.Strings(names)
+
+		base := coordinator.limit / len(names)
+		remainder := coordinator.limit % len(names)
+
+		for i, name := range names {
+			share := base
+			if i < remainder || share == 0 {
+				share++
+			}
+
+			coordinator.nodes[name].limiter.SetLimit(share)
+		}
+	}
+}
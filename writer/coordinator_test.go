@@ -0,0 +1,198 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// shares asks the coordinator for nothing on behalf of each node, and
+//   returns the share each was given.
+func shares(coordinator *Coordinator, nodes ...string) []int {
+	var result []int
+	for _, node := range nodes {
+		var reply api.AcquireReply
+		coordinator.Acquire(api.AcquireArgs{Node: node, Count: 0}, &reply)
+		result = append(result, reply.Share)
+	}
+
+	return result
+}
+
+func TestCoordinatorShares(t *testing.T) {
+	tests := []struct {
+		nodes    []string
+		expected []int
+	}{
+		{[]string{"a"}, []int{20}},
+		{[]string{"a", "b"}, []int{10, 10}},
+		{[]string{"a", "b", "c"}, []int{7, 7, 6}},
+		{[]string{"c", "b", "a"}, []int{6, 7, 7}},
+	}
+
+	for _, test := range tests {
+		coordinator := NewCoordinator(testConfig())
+		shares(coordinator, test.nodes...)
+
+		got := shares(coordinator, test.nodes...)
+		total := 0
+		for i, share := range got {
+			total += share
+			if share != test.expected[i] {
+				t.Error(test.nodes, got, "The limit was split wrong.")
+				break
+			}
+		}
+
+		if total != 20 {
+			t.Error(test.nodes, total, "The shares should add up to the limit.")
+		}
+	}
+
+	// With more nodes than the limit, every node can still send, but the
+	//   account as a whole stays within the limit.
+	config := testConfig()
+	config.Irc.MessageLimit = 2
+	coordinator := NewCoordinator(config)
+	shares(coordinator, "a", "b", "c")
+	if got := shares(coordinator, "a", "b", "c"); got[0] != 1 || got[1] != 1 || got[2] != 1 {
+		t.Error(got, "Every node should get a share of at least 1.")
+	}
+
+	sent := 0
+	for _, node := range []string{"a", "b", "c"} {
+		var reply api.AcquireReply
+		coordinator.Acquire(api.AcquireArgs{Node: node, Count: 1}, &reply)
+		if reply.Wait == 0 {
+			sent++
+		}
+	}
+	if sent != 2 {
+		t.Error(sent, "The account's limit should still hold across the nodes.")
+	}
+
+	// A node leaving hands its share to the rest.
+	coordinator = NewCoordinator(testConfig())
+	shares(coordinator, "a", "b", "c")
+
+	var reply bool
+	coordinator.Release("c", &reply)
+	if got := shares(coordinator, "a", "b"); got[0] != 10 || got[1] != 10 {
+		t.Error(got, "The shares were not rebalanced after a node left.")
+	}
+}
+
+func TestCoordinatorAcquire(t *testing.T) {
+	coordinator := NewCoordinator(testConfig())
+	shares(coordinator, "a", "b")
+
+	tests := []struct {
+		name      string
+		count     int
+		moderator bool
+		granted   bool
+	}{
+		{"within the share", 10, false, true},
+		{"over the share", 1, false, false},
+		{"moderator", 50, true, true},
+		{"over the moderator limit", 51, true, false},
+	}
+
+	for _, test := range tests {
+		var reply api.AcquireReply
+		args := api.AcquireArgs{Node: "a", Count: test.count, Moderator: test.moderator}
+		if err := coordinator.Acquire(args, &reply); err != nil {
+			t.Fatal(test.name, err)
+		}
+
+		if (reply.Wait == 0) != test.granted {
+			t.Error(test.name, reply, "The tokens were handed out wrong.")
+		}
+	}
+
+	// The other node's share is its own.
+	var reply api.AcquireReply
+	coordinator.Acquire(api.AcquireArgs{Node: "b", Count: 10}, &reply)
+	if reply.Wait != 0 {
+		t.Error(reply, "One node used up another's share.")
+	}
+}
+
+func TestCoordinatorLease(t *testing.T) {
+	config := testConfig()
+	config.Irc.WriterAccounts = []string{"one", "two"}
+	coordinator := NewCoordinator(config)
+
+	var first, second, again string
+	if err := coordinator.Lease("a", &first); err != nil || first != "one" {
+		t.Error(first, err, "The first account should have been leased.")
+	}
+
+	if err := coordinator.Lease("b", &second); err != nil || second != "two" {
+		t.Error(second, err, "The next free account should have been leased.")
+	}
+
+	if err := coordinator.Lease("a", &again); err != nil || again != first {
+		t.Error(again, err, "A node should get its own account back.")
+	}
+
+	var none string
+	if err := coordinator.Lease("c", &none); err == nil {
+		t.Error(none, "There should be no account left to lease.")
+	}
+
+	// Nodes on their own accounts each have the whole limit.
+	if got := shares(coordinator, "a", "b"); got[0] != 20 || got[1] != 20 {
+		t.Error(got, "Every account should have its own limit.")
+	}
+
+	var holder string
+	if err := coordinator.Holder("two", &holder); err != nil || holder != "b" {
+		t.Error(holder, err, "The holder of the account was not found.")
+	}
+
+	// A node that leaves frees its account.
+	var reply bool
+	coordinator.Release("b", &reply)
+
+	if err := coordinator.Holder("two", &holder); err == nil {
+		t.Error(holder, "The account should have been freed.")
+	}
+
+	if err := coordinator.Lease("c", &none); err != nil || none != "two" {
+		t.Error(none, err, "The freed account should be leased again.")
+	}
+}
+
+func TestCoordinatorClaim(t *testing.T) {
+	config := testConfig()
+	config.Irc.WriterAccounts = []string{"one", "two"}
+	coordinator := NewCoordinator(config)
+
+	// A restarted master knows nothing of the leases the nodes hold, so
+	//   they tell it.
+	var reply bool
+	coordinator.Report(api.ReportArgs{Node: "a", Account: "two"}, &reply)
+
+	var holder string
+	if err := coordinator.Holder("two", &holder); err != nil || holder != "a" {
+		t.Error(holder, err, "The node should have its account back.")
+	}
+
+	var account string
+	if err := coordinator.Lease("b", &account); err != nil || account != "one" {
+		t.Error(account, err, "A claimed account should not be leased again.")
+	}
+
+	// An account leased to another node stays with it.
+	var acquired api.AcquireReply
+	coordinator.Acquire(api.AcquireArgs{Node: "c", Account: "one"}, &acquired)
+	if err := coordinator.Holder("one", &holder); err != nil || holder != "b" {
+		t.Error(holder, err, "The account should have stayed with its holder.")
+	}
+
+	// Nodes on their own accounts each have the whole limit.
+	if got := shares(coordinator, "a", "b"); got[0] != 20 || got[1] != 20 {
+		t.Error(got, "The claimed account should have its own limit.")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Twitch drops a message that matches one sent to the same channel within
+//   this long.
+const duplicateWindow = 30 * time.Second
+
+// An invisible character Twitch does not strip, which makes an otherwise
+//   identical message unique. Chat messages are split short enough that
+//   one always fits.
+const duplicateVariation = " \U000E0000"
+
+// What the writer does when asked to send a duplicate message. Anything
+//   other than suppress is treated as vary.
+const (
+	duplicateVary     = "vary"
+	duplicateSuppress = "suppress"
+)
+
+// The responses Do gives when a duplicate was handled.
+const (
+	responseSuppressed = "DUPLICATE SUPPRESSED"
+	responseVaried     = "DUPLICATE VARIED"
+)
+
+// recentMessages remembers what was sent to each channel recently.
+type recentMessages struct {
+	sync.Mutex
+	channels map[string]map[string]time.Time
+}
+
+func newRecentMessages() *recentMessages {
+	return &recentMessages{
+		channels: make(map[string]map[string]time.Time),
+	}
+}
+
+// Claim records that text is being sent to a channel, unless it was sent
+//   within the window, and returns whether it was. The check and the record
+//   are one step so two sends of the same text can't both claim it.
+//   Anything older than the window is forgotten along the way.
+func (recent *recentMessages) Claim(channel string, text string) bool {
+	recent.Lock()
+	defer recent.Unlock()
+
+	messages, ok := recent.channels[channel]
+	if !ok {
+		messages = make(map[string]time.Time)
+		recent.channels[channel] = messages
+	}
+
+	for old, sent := range messages {
+		if time.Since(sent) >= duplicateWindow {
+			delete(messages, old)
+		}
+	}
+
+	if _, ok := messages[text]; ok {
+		return false
+	}
+
+	messages[text] = time.Now()
+	return true
+}
+
+// Release forgets text claimed for a channel, for when it was never sent
+//   after all. Otherwise a retry of the same text would be taken for a
+//   duplicate of a message Twitch never saw.
+func (recent *recentMessages) Release(channel string, text string) {
+	recent.Lock()
+	defer recent.Unlock()
+
+	delete(recent.channels[channel], text)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+func TestHandleDuplicates(t *testing.T) {
+	long := strings.Repeat("a", maxChatLength)
+	full := strings.Repeat("b", common.MaxMessageLength)
+
+	tests := []struct {
+		name     string
+		policy   string
+		sent     []string
+		text     string
+		expected string
+		response string
+	}{
+		{"new", duplicateVary, nil, "hi", "hi", ""},
+		{"suppressed", duplicateSuppress, []string{"hi"}, "hi", "", responseSuppressed},
+		{"varied", duplicateVary, []string{"hi"}, "hi", "hi" + duplicateVariation, responseVaried},
+		{"varied twice", duplicateVary, []string{"hi", "hi"}, "hi", "hi" + duplicateVariation + duplicateVariation, responseVaried},
+		{"split piece varied", duplicateVary, []string{long}, long, long + duplicateVariation, responseVaried},
+		{"no room to vary", duplicateVary, []string{full}, full, "", responseSuppressed},
+	}
+
+	for _, test := range tests {
+		config := testConfig()
+		config.Irc.DuplicatePolicy = test.policy
+		worker := &Writer{config: config, recent: newRecentMessages()}
+
+		for _, text := range test.sent {
+			worker.handleDuplicates([]*command{{name: "SAY", channel: "#a", text: text}})
+		}
+
+		pieces, response := worker.handleDuplicates([]*command{{name: "SAY", channel: "#a", text: test.text}})
+		if response != test.response {
+			t.Error(test.name, response, "The wrong response was given.")
+		}
+
+		if test.expected == "" {
+			if len(pieces) != 0 {
+				t.Error(test.name, "The duplicate should not have been sent.")
+			}
+			continue
+		}
+
+		if len(pieces) != 1 || pieces[0].text != test.expected {
+			t.Error(test.name, pieces, "The wrong text would have been sent.")
+			continue
+		}
+
+		if len([]rune(pieces[0].text)) > common.MaxMessageLength {
+			t.Error(test.name, "The varied text is longer than Twitch accepts.")
+		}
+	}
+
+	// Other channels and other commands are left alone.
+	worker := &Writer{config: testConfig(), recent: newRecentMessages()}
+	worker.handleDuplicates([]*command{{name: "SAY", channel: "#a", text: "hi"}})
+
+	pieces, response := worker.handleDuplicates([]*command{
+		{name: "SAY", channel: "#b", text: "hi"},
+		{name: "BAN", channel: "#a", target: "user"},
+		{name: "BAN", channel: "#a", target: "user"},
+	})
+	if len(pieces) != 3 || response != "" {
+		t.Error(pieces, response, "Only repeated chat in one channel is a duplicate.")
+	}
+}
+
+func TestRecentMessagesClaim(t *testing.T) {
+	recent := newRecentMessages()
+
+	// Only one of many sends of the same text at once gets to claim it.
+	var claimed sync.WaitGroup
+	results := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		claimed.Add(1)
+		go func() {
+			defer claimed.Done()
+			results <- recent.Claim("#a", "hi")
+		}()
+	}
+	claimed.Wait()
+	close(results)
+
+	count := 0
+	for result := range results {
+		if result {
+			count++
+		}
+	}
+
+	if count != 1 {
+		t.Error(count, "The text should have been claimed exactly once.")
+	}
+
+	if !recent.Claim("#b", "hi") {
+		t.Error("Text sent to one channel should not count against another.")
+	}
+}
+
+func TestSendReleasesClaim(t *testing.T) {
+	worker := &Writer{config: testConfig(), recent: newRecentMessages(), queue: newSendQueue()}
+	worker.queue.Close(common.ErrHalted)
+
+	// A send that fails leaves the text free for a retry.
+	pieces := []*command{{name: "SAY", channel: "#a", text: "hi"}}
+	if _, err := worker.send(pieces); !errors.Is(err, common.ErrHalted) {
+		t.Error(err, "The send should have failed.")
+	}
+
+	if !worker.recent.Claim("#a", "hi") {
+		t.Error("Text that was never sent should not count as a duplicate.")
+	}
+}
+
+func TestChatPiecesLeaveRoom(t *testing.T) {
+	cmd, err := parseCommand("SAY #a " + strings.Repeat("word ", 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pieces, err := cmd.pieces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, piece := range pieces {
+		if len([]rune(piece.text+duplicateVariation)) > common.MaxMessageLength {
+			t.Error(i, len([]rune(piece.text)), "A piece has no room left for a variation.")
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"time"
-
 	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common/api"
 )
 
 func NewStatusFactory() balancer.StatusFactory {
@@ -14,23 +13,6 @@ type Factory struct {
 }
 
 func (factory *Factory) Create() balancer.Status {
-	status := Status{}
+	status := api.WriterStatus{}
 	return &status
 }
-
-// TO DO: THIS ENTIRE FILE
-
-type Status struct {
-}
-
-func (status *Status) GetIdleTime() time.Duration {
-	return time.Minute
-}
-
-func (status *Status) String() string {
-	return time.Now().String()
-}
-
-func (status *Status) Update(z string) {
-
-}
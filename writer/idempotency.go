@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// How long the result of work sent with a key is remembered when the config
+//   doesn't say.
+const defaultIdempotencyExpiry = 10 * time.Minute
+
+// idempotencyExpiry returns how long the result of work sent with a key is
+//   remembered.
+func idempotencyExpiry(config *common.Config) time.Duration {
+	if config.Irc.IdempotencyExpiry <= 0 {
+		return defaultIdempotencyExpiry
+	}
+
+	return time.Duration(config.Irc.IdempotencyExpiry) * time.Second
+}
+
+// sendResults remembers the result of work sent with an idempotency key.
+//   The balancer may retry Do when a reply is lost, and the key is how the
+//   writer knows it already sent the message.
+type sendResults struct {
+	sync.Mutex
+	keep    time.Duration
+	results map[string]*sendResult
+}
+
+type sendResult struct {
+	done     chan struct{}
+	response string
+	err      error
+	finished time.Time
+}
+
+func newSendResults(keep time.Duration) *sendResults {
+	return &sendResults{
+		keep:    keep,
+		results: make(map[string]*sendResult),
+	}
+}
+
+// Begin claims a key. If the key was already claimed, the existing result
+//   is returned instead and the caller should wait on it.
+func (results *sendResults) Begin(key string) (*sendResult, bool) {
+	results.Lock()
+	defer results.Unlock()
+
+	results.expire()
+
+	if result, ok := results.results[key]; ok {
+		return result, true
+	}
+
+	result := &sendResult{done: make(chan struct{})}
+	results.results[key] = result
+
+	return result, false
+}
+
+// Restore records the result of a key that was finished before a restart.
+func (results *sendResults) Restore(key string, response string, errText string, finished time.Time) {
+	result := &sendResult{
+		done:     make(chan struct{}),
+		response: response,
+		finished: finished,
+	}
+
+	if errText != "" {
+		result.err = errors.New(errText)
+	}
+	close(result.done)
+
+	results.Lock()
+	results.results[key] = result
+	results.Unlock()
+}
+
+// Finish stores the result of a key and wakes anyone waiting on it. A
+//   failure that might not happen again, like a lost connection, is not
+//   kept, so a retry of the key sends the work again.
+func (results *sendResults) Finish(result *sendResult, response string, err error) {
+	results.Lock()
+	result.response = response
+	result.err = err
+	result.finished = time.Now()
+
+	if !isFinal(err) {
+		for key, other := range results.results {
+			if other == result {
+				delete(results.results, key)
+			}
+		}
+	}
+	results.Unlock()
+
+	close(result.done)
+}
+
+// isFinal returns whether a result would be the same if the work was tried
+//   again: a success, or work that was turned down as invalid.
+func isFinal(err error) bool {
+	var invalid *balancer.InvalidWorkError
+	return err == nil || errors.As(err, &invalid)
+}
+
+// spoolKey returns the key to spool a result under, which is none when the
+//   result is not kept.
+func spoolKey(key string, err error) string {
+	if !isFinal(err) {
+		return ""
+	}
+
+	return key
+}
+
+// Wait blocks until a result is finished and returns it.
+func (result *sendResult) Wait() (string, error) {
+	<-result.done
+	return result.response, result.err
+}
+
+// expire forgets keys that finished too long ago. The lock must be held by
+//   the caller.
+func (results *sendResults) expire() {
+	for key, result := range results.results {
+		if !result.finished.IsZero() && time.Since(result.finished) > results.keep {
+			delete(results.results, key)
+		}
+	}
+}
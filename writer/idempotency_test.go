@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/balancer"
+)
+
+func TestSendResults(t *testing.T) {
+	results := newSendResults(time.Minute)
+
+	tests := []struct {
+		name string
+		err  error
+		kept bool
+	}{
+		{"success", nil, true},
+		{"invalid", &balancer.InvalidWorkError{Str: "Bad work."}, true},
+		{"lost connection", errors.New("The connection to IRC was lost."), false},
+	}
+
+	for _, test := range tests {
+		result, existing := results.Begin(test.name)
+		if existing {
+			t.Fatal(test.name, "A new key was already claimed.")
+		}
+
+		// A retry that comes in while the first attempt is running waits
+		//   on it either way.
+		retry, existing := results.Begin(test.name)
+		if !existing {
+			t.Error(test.name, "A claimed key was not found.")
+		}
+
+		results.Finish(result, "response", test.err)
+		if _, err := retry.Wait(); err != test.err {
+			t.Error(test.name, err, "The waiting retry did not get the result.")
+		}
+
+		_, existing = results.Begin(test.name)
+		if existing != test.kept {
+			t.Error(test.name, existing, "Whether the result was kept was not as expected.")
+		}
+
+		if key := spoolKey(test.name, test.err); (key != "") != test.kept {
+			t.Error(test.name, key, "The spooled key was not as expected.")
+		}
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	// Keys are remembered for a while even when the spool does not keep
+	//   work around.
+	config := testConfig()
+	config.Irc.SpoolExpiry = 0
+	if keep := idempotencyExpiry(config); keep != defaultIdempotencyExpiry {
+		t.Error(keep, "Keys should be remembered for the default time.")
+	}
+
+	config.Irc.IdempotencyExpiry = 30
+	if keep := idempotencyExpiry(config); keep != 30*time.Second {
+		t.Error(keep, "Keys should be remembered for the configured time.")
+	}
+}
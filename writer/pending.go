@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v1"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// pendingSends holds chat messages that were written but not confirmed.
+//   Twitch never says a message went through, it only sends a NOTICE when
+//   one is rejected. A message counts as sent once a USERSTATE echoes it, or
+//   once the window passes without a rejection.
+type pendingSends struct {
+	sync.Mutex
+	window   time.Duration
+	channels map[string][]*pendingSend
+}
+
+type pendingSend struct {
+	done chan error
+}
+
+func newPendingSends(window time.Duration) *pendingSends {
+	return &pendingSends{
+		window:   window,
+		channels: make(map[string][]*pendingSend),
+	}
+}
+
+// Add waits on the result of a message just written to a channel. The
+//   result is given to done.
+func (pending *pendingSends) Add(channel string, done chan error) {
+	send := &pendingSend{done}
+
+	pending.Lock()
+	pending.channels[channel] = append(pending.channels[channel], send)
+	pending.Unlock()
+
+	time.AfterFunc(pending.window, func() {
+		pending.finish(channel, send)
+	})
+}
+
+// Update reads a line from IRC and settles the oldest pending message of
+//   the channel it is about, if it is a rejection or a confirmation.
+func (pending *pendingSends) Update(line string) {
+	tags, rest := common.ParseTags(line)
+
+	message := irc.ParseMessage(rest)
+	if message == nil || len(message.Params) == 0 {
+		return
+	}
+	channel := message.Params[0]
+
+	switch message.Command {
+	case irc.NOTICE:
+		reason := tags["msg-id"]
+		if common.IsRejection(reason) {
+			pending.resolve(channel, &common.SendError{
+				Reason:  reason,
+				Channel: channel,
+				Notice:  message.Trailing,
+			})
+		}
+	case "USERSTATE":
+		pending.resolve(channel, nil)
+	}
+}
+
+// resolve settles the oldest pending message of a channel.
+func (pending *pendingSends) resolve(channel string, err error) {
+	pending.Lock()
+	sends := pending.channels[channel]
+	if len(sends) == 0 {
+		pending.Unlock()
+		return
+	}
+
+	send := sends[0]
+	pending.remove(channel, 0)
+	pending.Unlock()
+
+	send.done <- err
+}
+
+// finish settles a message as sent once its window is up, unless it was
+//   already settled.
+func (pending *pendingSends) finish(channel string, send *pendingSend) {
+	pending.Lock()
+	for i, other := range pending.channels[channel] {
+		if other == send {
+			pending.remove(channel, i)
+			pending.Unlock()
+
+			send.done <- nil
+			return
+		}
+	}
+	pending.Unlock()
+}
+
+// remove drops the pending message at index i. The lock must be held by
+//   the caller.
+func (pending *pendingSends) remove(channel string, i int) {
+	sends := pending.channels[channel]
+	sends = append(sends[:i], sends[i+1:]...)
+
+	if len(sends) == 0 {
+		delete(pending.channels, channel)
+	} else {
+		pending.channels[channel] = sends
+	}
+}
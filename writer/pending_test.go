@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// result waits a little on a pending send's result.
+func result(done chan error) (bool, error) {
+	select {
+	case err := <-done:
+		return true, err
+	case <-time.After(100 * time.Millisecond):
+		return false, nil
+	}
+}
+
+func TestPendingSends(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		reason string
+		sent   bool
+	}{
+		{"duplicate", "@msg-id=msg_duplicate :tmi.twitch.tv NOTICE #a :Your message is identical.", common.ReasonDuplicate, false},
+		{"slow mode", "@msg-id=msg_slowmode :tmi.twitch.tv NOTICE #a :This room is in slow mode.", common.ReasonSlowMode, false},
+		{"banned", "@msg-id=msg_banned :tmi.twitch.tv NOTICE #a :You are banned.", common.ReasonBanned, false},
+		{"echoed", "@mod=0 :tmi.twitch.tv USERSTATE #a", "", true},
+		{"not a rejection", "@msg-id=host_on :tmi.twitch.tv NOTICE #a :Now hosting.", "", false},
+		{"other channel", "@msg-id=msg_duplicate :tmi.twitch.tv NOTICE #b :Your message is identical.", "", false},
+	}
+
+	for _, test := range tests {
+		pending := newPendingSends(time.Minute)
+		done := make(chan error, 1)
+		pending.Add("#a", done)
+
+		pending.Update(test.line)
+		settled, err := result(done)
+
+		switch {
+		case test.reason != "":
+			sendErr, ok := err.(*common.SendError)
+			if !settled || !ok || sendErr.Reason != test.reason || sendErr.Channel != "#a" {
+				t.Error(test.name, err, "The send should have been rejected.")
+			}
+		case test.sent:
+			if !settled || err != nil {
+				t.Error(test.name, err, "The send should have gone through.")
+			}
+		default:
+			if settled {
+				t.Error(test.name, err, "The line should not have settled the send.")
+			}
+		}
+	}
+}
+
+func TestPendingSendsOrder(t *testing.T) {
+	pending := newPendingSends(50 * time.Millisecond)
+
+	// A NOTICE is about the oldest message still pending in the channel.
+	first, second := make(chan error, 1), make(chan error, 1)
+	pending.Add("#a", first)
+	pending.Add("#a", second)
+
+	pending.Update("@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE #a :Too fast.")
+	if settled, err := result(first); !settled || err == nil {
+		t.Error(err, "The oldest send should have been rejected.")
+	}
+
+	// Without a rejection, the window passing means it went through.
+	if settled, err := result(second); !settled || err != nil {
+		t.Error(err, "A send without a rejection should go through.")
+	}
+
+	// Nothing is left to settle, so a late NOTICE is ignored.
+	pending.Update("@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE #a :Too fast.")
+	if len(pending.channels) != 0 {
+		t.Error(pending.channels, "Nothing should be left pending.")
+	}
+}
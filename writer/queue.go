@@ -0,0 +1,290 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v1"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// A priority decides which payloads are written first. Lower values are
+//   written before higher ones.
+type priority int
+
+const (
+	priorityProtocol priority = iota
+	priorityModeration
+	priorityReply
+	priorityAnnouncement
+	priorityCount
+)
+
+var priorityNames = [priorityCount]string{
+	"protocol",
+	"moderation",
+	"reply",
+	"announcement",
+}
+
+func (p priority) String() string {
+	return priorityNames[p]
+}
+
+// moderationCommands are the chat commands that moderate a channel.
+var moderationCommands = []string{
+	"/timeout",
+	"/untimeout",
+	"/ban",
+	"/unban",
+	"/delete",
+	"/clear",
+}
+
+// classify determines the priority and channel of a raw IRC line. Chat
+//   messages are treated as announcements unless they moderate or reply.
+func classify(line string) (priority, string) {
+	tags, rest := common.ParseTags(line)
+
+	message := irc.ParseMessage(rest)
+	if message == nil || message.Command != irc.PRIVMSG {
+		return priorityProtocol, ""
+	}
+
+	channel := ""
+	if len(message.Params) > 0 {
+		channel = message.Params[0]
+	}
+
+	for _, command := range moderationCommands {
+		if message.Trailing == command ||
+			strings.HasPrefix(message.Trailing, command+" ") {
+			return priorityModeration, channel
+		}
+	}
+
+	if _, ok := tags["reply-parent-msg-id"]; ok {
+		return priorityReply, channel
+	}
+
+	return priorityAnnouncement, channel
+}
+
+// A sendQueue orders payloads by priority. Within a priority, channels
+//   take turns so a busy channel cannot hold up the others. Once closed, the
+//   error it was closed with is what every payload gets.
+type sendQueue struct {
+	sync.Mutex
+	classes [priorityCount]queueClass
+	signal  chan struct{}
+	err     error
+}
+
+type queueClass struct {
+	channels []string
+	pending  map[string][]writePayload
+	next     int
+}
+
+func newSendQueue() *sendQueue {
+	queue := sendQueue{}
+	queue.signal = make(chan struct{}, 1)
+
+	for i := range queue.classes {
+		queue.classes[i].pending = make(map[string][]writePayload)
+	}
+
+	return &queue
+}
+
+// Push adds payloads to the back of their channel's line. Payloads pushed
+//   together are kept together in the order given.
+func (queue *sendQueue) Push(payloads ...writePayload) {
+	queue.Lock()
+	if queue.err != nil {
+		queue.Unlock()
+		fail(payloads, queue.err)
+		return
+	}
+
+	for _, payload := range payloads {
+		class := &queue.classes[payload.priority]
+		if _, ok := class.pending[payload.channel]; !ok {
+			class.channels = append(class.channels, payload.channel)
+		}
+		class.pending[payload.channel] = append(class.pending[payload.channel], payload)
+	}
+	queue.Unlock()
+
+	// Wake up Pop if it is waiting. If a wake up is already pending, there
+	//   is no need for another.
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Return puts a payload that was popped but never written back at the
+//   front of its channel's line, so it still goes ahead of whatever was
+//   queued after it. A channel that had left the rotation takes the next
+//   turn.
+func (queue *sendQueue) Return(payload writePayload) {
+	queue.Lock()
+	if queue.err != nil {
+		queue.Unlock()
+		fail([]writePayload{payload}, queue.err)
+		return
+	}
+
+	class := &queue.classes[payload.priority]
+	if _, ok := class.pending[payload.channel]; !ok {
+		channels := make([]string, 0, len(class.channels)+1)
+		channels = append(channels, class.channels[:class.next]...)
+		channels = append(channels, payload.channel)
+		class.channels = append(channels, class.channels[class.next:]...)
+	}
+	class.pending[payload.channel] = append([]writePayload{payload}, class.pending[payload.channel]...)
+	queue.Unlock()
+
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Pop blocks until there is a payload ready to write and returns it. The
+//   ready function says how long a payload has to wait before it can be
+//   written. Channels that are waiting are skipped so they hold up nobody.
+//   A closed queue only returns empty payloads.
+func (queue *sendQueue) Pop(ready func(writePayload) time.Duration) writePayload {
+	for {
+		var soonest time.Duration
+
+		queue.Lock()
+		if queue.err != nil {
+			queue.Unlock()
+			return newPayload("", priorityProtocol, "")
+		}
+
+		for i := range queue.classes {
+			payload, wait, ok := queue.classes[i].pop(ready)
+			if ok {
+				queue.Unlock()
+				return payload
+			}
+
+			if wait > 0 && (soonest == 0 || wait < soonest) {
+				soonest = wait
+			}
+		}
+		queue.Unlock()
+
+		if soonest == 0 {
+			<-queue.signal
+			continue
+		}
+
+		timer := time.NewTimer(soonest)
+		select {
+		case <-queue.signal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Close fails every payload still queued with err, along with any pushed
+//   from then on, and wakes up Pop.
+func (queue *sendQueue) Close(err error) {
+	var dropped []writePayload
+
+	queue.Lock()
+	if queue.err == nil {
+		queue.err = err
+		for i := range queue.classes {
+			class := &queue.classes[i]
+			for _, channel := range class.channels {
+				dropped = append(dropped, class.pending[channel]...)
+			}
+
+			class.channels = nil
+			class.pending = make(map[string][]writePayload)
+			class.next = 0
+		}
+	}
+	queue.Unlock()
+
+	fail(dropped, err)
+
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+	}
+}
+
+// fail gives every payload an error without writing it.
+func fail(payloads []writePayload, err error) {
+	for _, payload := range payloads {
+		payload.doneChan <- err
+	}
+}
+
+// Depths returns how many payloads are waiting in each priority.
+func (queue *sendQueue) Depths() map[string]int {
+	queue.Lock()
+	defer queue.Unlock()
+
+	depths := make(map[string]int)
+	for i, class := range queue.classes {
+		depth := 0
+		for _, payloads := range class.pending {
+			depth += len(payloads)
+		}
+
+		depths[priority(i).String()] = depth
+	}
+
+	return depths
+}
+
+// pop takes the first ready payload, starting with the channel whose turn
+//   it is. If nothing is ready, it returns how long until something is.
+func (class *queueClass) pop(ready func(writePayload) time.Duration) (writePayload, time.Duration, bool) {
+	var soonest time.Duration
+
+	for k := 0; k < len(class.channels); k++ {
+		i := (class.next + k) % len(class.channels)
+		payload := class.pending[class.channels[i]][0]
+
+		wait := ready(payload)
+		if wait <= 0 {
+			class.remove(i)
+			return payload, 0, true
+		}
+
+		if soonest == 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+
+	return writePayload{}, soonest, false
+}
+
+// remove takes the first payload off the channel at index i.
+func (class *queueClass) remove(i int) {
+	channel := class.channels[i]
+	payloads := class.pending[channel]
+
+	if len(payloads) == 1 {
+		// The channel is out of payloads, so it leaves the rotation and the
+		//   channel after it moves into its spot.
+		delete(class.pending, channel)
+		class.channels = append(class.channels[:i], class.channels[i+1:]...)
+		class.next = i
+	} else {
+		class.pending[channel] = payloads[1:]
+		class.next = i + 1
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// ready lets every payload be written right away.
+func ready(writePayload) time.Duration {
+	return 0
+}
+
+func TestSendQueueReturn(t *testing.T) {
+	queue := newSendQueue()
+
+	pieces := []writePayload{
+		newPayload("PRIVMSG #a :one", priorityAnnouncement, "#a"),
+		newPayload("PRIVMSG #a :two", priorityAnnouncement, "#a"),
+		newPayload("PRIVMSG #a :three", priorityAnnouncement, "#a"),
+	}
+	pieces[0].tokens = 3
+	pieces[1].tokens = 0
+	pieces[2].tokens = 0
+	queue.Push(pieces...)
+
+	// The first piece is popped, but the connection dies before it is
+	//   written.
+	popped := queue.Pop(ready)
+	queue.Return(popped)
+
+	for i, expected := range pieces {
+		payload := queue.Pop(ready)
+		if payload.msg != expected.msg || payload.tokens != expected.tokens {
+			t.Error(i, payload.msg, payload.tokens, "The pieces were not kept in order.")
+		}
+	}
+
+	// A channel that emptied out gets its turn back.
+	queue.Push(newPayload("PRIVMSG #b :b", priorityAnnouncement, "#b"))
+	queue.Push(newPayload("PRIVMSG #c :c", priorityAnnouncement, "#c"))
+	queue.Return(newPayload("PRIVMSG #a :again", priorityAnnouncement, "#a"))
+
+	if payload := queue.Pop(ready); payload.msg != "PRIVMSG #a :again" {
+		t.Error(payload.msg, "The returned payload should go first.")
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	queue := newSendQueue()
+
+	waiting := newPayload("PRIVMSG #a :queued", priorityAnnouncement, "#a")
+	queue.Push(waiting)
+
+	// A work loop waiting on the queue wakes up once it is closed.
+	popped := make(chan writePayload)
+	go func() {
+		queue.Pop(func(writePayload) time.Duration { return time.Hour })
+		popped <- queue.Pop(ready)
+	}()
+
+	queue.Close(common.ErrHalted)
+
+	select {
+	case payload := <-popped:
+		if payload.msg != "" {
+			t.Error(payload.msg, "A closed queue should only give empty payloads.")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Closing the queue did not wake up Pop.")
+	}
+
+	if err := <-waiting.doneChan; err != common.ErrHalted {
+		t.Error(err, "A queued payload should fail once the queue is closed.")
+	}
+
+	late := newPayload("PRIVMSG #a :late", priorityAnnouncement, "#a")
+	queue.Push(late)
+	if err := <-late.doneChan; err != common.ErrHalted {
+		t.Error(err, "A payload pushed after closing should fail.")
+	}
+
+	if depths := queued(queue.Depths()); depths != 0 {
+		t.Error(depths, "Nothing should be left in a closed queue.")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		line     string
+		priority priority
+		channel  string
+	}{
+		{"PASS oauth:token", priorityProtocol, ""},
+		{"JOIN #a", priorityProtocol, ""},
+		{"PRIVMSG #a :hello", priorityAnnouncement, "#a"},
+		{"PRIVMSG #a :/ban user", priorityModeration, "#a"},
+		{"PRIVMSG #a :/timeout user 10 spam", priorityModeration, "#a"},
+		{"PRIVMSG #a :/bang", priorityAnnouncement, "#a"},
+		{"@reply-parent-msg-id=abc PRIVMSG #a :hi", priorityReply, "#a"},
+	}
+
+	for _, test := range tests {
+		priority, channel := classify(test.line)
+		if priority != test.priority || channel != test.channel {
+			t.Error(test.line, priority, channel, "The line was classified wrong.")
+		}
+	}
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	queue := newSendQueue()
+
+	// A busy channel queues up first, but the others still get turns.
+	queue.Push(
+		newPayload("PRIVMSG #a :a1", priorityAnnouncement, "#a"),
+		newPayload("PRIVMSG #a :a2", priorityAnnouncement, "#a"),
+		newPayload("PRIVMSG #a :a3", priorityAnnouncement, "#a"),
+	)
+	queue.Push(newPayload("PRIVMSG #b :b1", priorityAnnouncement, "#b"))
+	queue.Push(newPayload("PRIVMSG #c :c1", priorityAnnouncement, "#c"))
+
+	// Higher priorities go ahead of everything already queued.
+	queue.Push(newPayload("PRIVMSG #c :/ban user", priorityModeration, "#c"))
+	queue.Push(newPayload("@reply-parent-msg-id=x PRIVMSG #b :r", priorityReply, "#b"))
+	queue.Push(newPayload("PONG :tmi.twitch.tv", priorityProtocol, ""))
+
+	expected := []string{
+		"PONG :tmi.twitch.tv",
+		"PRIVMSG #c :/ban user",
+		"@reply-parent-msg-id=x PRIVMSG #b :r",
+		"PRIVMSG #a :a1",
+		"PRIVMSG #b :b1",
+		"PRIVMSG #c :c1",
+		"PRIVMSG #a :a2",
+		"PRIVMSG #a :a3",
+	}
+
+	for i, msg := range expected {
+		if payload := queue.Pop(ready); payload.msg != msg {
+			t.Error(i, payload.msg, "Expected "+msg+" to be next.")
+		}
+	}
+
+	// A channel that has to wait doesn't hold up the others.
+	queue.Push(newPayload("PRIVMSG #slow :s", priorityAnnouncement, "#slow"))
+	queue.Push(newPayload("PRIVMSG #fast :f", priorityAnnouncement, "#fast"))
+
+	until := time.Now().Add(20 * time.Millisecond)
+	wait := func(payload writePayload) time.Duration {
+		if payload.channel == "#slow" {
+			return time.Until(until)
+		}
+		return 0
+	}
+
+	if payload := queue.Pop(wait); payload.msg != "PRIVMSG #fast :f" {
+		t.Error(payload.msg, "A waiting channel held up the queue.")
+	}
+
+	if payload := queue.Pop(wait); payload.msg != "PRIVMSG #slow :s" {
+		t.Error(payload.msg, "A waiting channel should be written once it is ready.")
+	}
+
+	if depths := queued(queue.Depths()); depths != 0 {
+		t.Error(depths, "Everything should have been popped.")
+	}
+}
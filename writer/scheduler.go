@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// How long to wait before trying a scheduled send again after it failed
+//   for a reason that can pass. The wait doubles with each failure, up to
+//   the most.
+const (
+	scheduleRetry    = 30 * time.Second
+	maxScheduleRetry = 30 * time.Minute
+)
+
+// NewScheduler creates the service the writer master uses to hold sends
+//   until they are due. The writers are the ones in the registry, and the
+//   coordinator's reports say how busy they are. Anything saved at the
+//   schedule path is loaded.
+func NewScheduler(config *common.Config, registry common.Registry, coordinator *Coordinator) (*Scheduler, error) {
+	scheduler := Scheduler{
+		path:        config.Master.SchedulePath,
+		client:      config.Client,
+		registry:    registry,
+		coordinator: coordinator,
+		pending:     make(map[string]*scheduledSend),
+		wake:        make(chan struct{}, 1),
+	}
+
+	if scheduler.path == "" {
+		return &scheduler, nil
+	}
+
+	data, err := os.ReadFile(scheduler.path)
+	if os.IsNotExist(err) {
+		return &scheduler, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*scheduledSend
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	for _, send := range saved {
+		scheduler.pending[send.ID] = send
+	}
+
+	return &scheduler, nil
+}
+
+// A Scheduler holds writer work until it is due and then hands it to the
+//   least busy writer. Idempotency keys are only known to the writer that
+//   saw them, so once a send has gone to a writer, every retry goes to the
+//   same one for as long as it is registered.
+type Scheduler struct {
+	sync.Mutex
+	path        string
+	client      common.ClientConfig
+	registry    common.Registry
+	coordinator *Coordinator
+	pending     map[string]*scheduledSend
+	count       int
+	wake        chan struct{}
+}
+
+// A scheduledSend is a send in the schedule, along with when it may be
+//   tried again after failing. Only the send itself is saved.
+type scheduledSend struct {
+	api.ScheduledSend
+
+	retryAt  time.Time
+	failures int
+}
+
+// Schedule validates the work and holds it until it is due.
+func (scheduler *Scheduler) Schedule(args api.ScheduleArgs, reply *api.ScheduledSend) error {
+	if _, err := prepare(args.Work); err != nil {
+		return err
+	}
+
+	due := args.Due
+	if due.IsZero() {
+		due = time.Now().Add(args.Delay)
+	}
+
+	scheduler.Lock()
+	scheduler.count++
+	send := &scheduledSend{ScheduledSend: api.ScheduledSend{
+		ID:   strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(scheduler.count),
+		Work: args.Work,
+		Due:  due,
+	}}
+	scheduler.pending[send.ID] = send
+	err := scheduler.save()
+	scheduler.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	scheduler.notify()
+
+	*reply = send.ScheduledSend
+	return nil
+}
+
+// List returns every send that is waiting, soonest first. RPC methods need
+//   an argument, but this one is not used.
+func (scheduler *Scheduler) List(unused bool, reply *[]api.ScheduledSend) error {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	list := make([]api.ScheduledSend, 0, len(scheduler.pending))
+	for _, send := range scheduler.sorted() {
+		list = append(list, send.ScheduledSend)
+	}
+
+	*reply = list
+	return nil
+}
+
+// Cancel removes a send before it goes out.
+func (scheduler *Scheduler) Cancel(id string, reply *bool) error {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	if _, ok := scheduler.pending[id]; !ok {
+		return errors.New("No scheduled send with the id: " + id)
+	}
+
+	delete(scheduler.pending, id)
+	*reply = true
+
+	return scheduler.save()
+}
+
+// Dispatch sends work as it comes due, until ctx is cancelled.
+func (scheduler *Scheduler) Dispatch(ctx context.Context) error {
+	for {
+		scheduler.Lock()
+		now := time.Now()
+		wait := time.Hour
+		for _, send := range scheduler.sorted() {
+			at := send.Due
+			if send.retryAt.After(at) {
+				at = send.retryAt
+			}
+
+			if at.After(now) {
+				if at.Sub(now) < wait {
+					wait = at.Sub(now)
+				}
+				continue
+			}
+
+			// Hold it back from being sent again while it is in flight.
+			send.retryAt = now.Add(scheduleRetry)
+			go scheduler.send(send.ScheduledSend)
+		}
+		scheduler.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-scheduler.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// send hands a due send to a writer. The id is used as an idempotency key
+//   so a retry does not post it twice. The writer may take as long as it
+//   needs, like the load balancer lets it.
+func (scheduler *Scheduler) send(send api.ScheduledSend) {
+	node, err := scheduler.pick(send.ID)
+	if err == nil {
+		writer := api.NewNodeClient(node, scheduler.client)
+		_, err = writer.DoTimeout("@key=schedule-"+send.ID+" "+send.Work, 0)
+		writer.Close()
+	}
+
+	// Only work the writer found invalid fails the same way every time.
+	//   Anything else, like a writer that is draining or a rejection by
+	//   Twitch, may pass, so it is tried again later.
+	if !isFinal(err) {
+		fmt.Println("Scheduled send failed, retrying:", send.ID, err)
+		scheduler.retry(send.ID)
+		return
+	}
+
+	if err != nil {
+		fmt.Println("Scheduled send rejected:", send.ID, err)
+	}
+
+	scheduler.Lock()
+	delete(scheduler.pending, send.ID)
+	if err := scheduler.save(); err != nil {
+		fmt.Println("Saving the schedule failed:", err)
+	}
+	scheduler.Unlock()
+}
+
+// retry holds a send back for longer with each time it has failed.
+func (scheduler *Scheduler) retry(id string) {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	send, ok := scheduler.pending[id]
+	if !ok {
+		return
+	}
+
+	wait := scheduleRetry << send.failures
+	if wait <= 0 || wait > maxScheduleRetry {
+		wait = maxScheduleRetry
+	} else {
+		send.failures++
+	}
+	send.retryAt = time.Now().Add(wait)
+
+	scheduler.notify()
+}
+
+// pick returns the writer to hand a send to: the one it was handed to
+//   before if it is still registered, or else the one with the least
+//   queued. The choice is saved with the send.
+func (scheduler *Scheduler) pick(id string) (string, error) {
+	nodes, err := scheduler.registry.Nodes()
+	if err != nil {
+		return "", err
+	}
+
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	send, ok := scheduler.pending[id]
+	if !ok {
+		return "", errors.New("No scheduled send with the id: " + id)
+	}
+
+	for _, node := range nodes {
+		if node == send.Node {
+			return node, nil
+		}
+	}
+
+	if len(nodes) == 0 {
+		return "", errors.New("No writer is registered.")
+	}
+
+	reports := scheduler.coordinator.Reports()
+	best := ""
+	for _, node := range nodes {
+		if best == "" || queued(reports[node].QueueDepth) < queued(reports[best].QueueDepth) {
+			best = node
+		}
+	}
+
+	send.Node = best
+	if err := scheduler.save(); err != nil {
+		fmt.Println("Saving the schedule failed:", err)
+	}
+
+	return best, nil
+}
+
+// notify wakes up Dispatch so it sees a change to the schedule.
+func (scheduler *Scheduler) notify() {
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sorted returns the pending sends, soonest first. The lock must be held
+//   by the caller.
+func (scheduler *Scheduler) sorted() []*scheduledSend {
+	sends := make([]*scheduledSend, 0, len(scheduler.pending))
+	for _, send := range scheduler.pending {
+		sends = append(sends, send)
+	}
+
+	sort.Slice(sends, func(i, j int) bool {
+		return sends[i].Due.Before(sends[j].Due)
+	})
+
+	return sends
+}
+
+// save writes the schedule to disk, if it has a path. The lock must be
+//   held by the caller.
+func (scheduler *Scheduler) save() error {
+	if scheduler.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(scheduler.sorted())
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(scheduler.path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(scheduler.path+".tmp", scheduler.path)
+}
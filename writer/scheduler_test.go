@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// A recordingWorker keeps the work it is given.
+type recordingWorker struct {
+	sync.Mutex
+	work []string
+}
+
+func (worker *recordingWorker) Do(work string) (string, error) {
+	worker.Lock()
+	defer worker.Unlock()
+
+	worker.work = append(worker.work, work)
+	return "", nil
+}
+
+func (worker *recordingWorker) Status(requestTime time.Time) balancer.Status {
+	return &api.WriterStatus{}
+}
+
+func (worker *recordingWorker) Work() []string {
+	worker.Lock()
+	defer worker.Unlock()
+
+	return append([]string(nil), worker.work...)
+}
+
+// A failingWorker turns down all work with the same error.
+type failingWorker struct {
+	err error
+}
+
+func (worker *failingWorker) Do(work string) (string, error) {
+	return "", worker.err
+}
+
+func (worker *failingWorker) Status(requestTime time.Time) balancer.Status {
+	return &api.WriterStatus{}
+}
+
+// serveWriter runs a node around a worker and returns its name.
+func serveWriter(t *testing.T, worker balancer.Worker) string {
+	listener, port, err := common.Listen("localhost", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	node, err := common.NewNode(listener, worker, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.ListenAndServe()
+
+	return "localhost:" + strconv.Itoa(port)
+}
+
+// newTestScheduler returns a scheduler over a registry of the given nodes.
+func newTestScheduler(t *testing.T, nodes ...string) (*Scheduler, *common.FileRegistry, *Coordinator) {
+	dir, err := os.MkdirTemp("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	registry := common.NewFileRegistry(filepath.Join(dir, "nodes.txt"), time.Minute)
+	for _, node := range nodes {
+		if err := registry.Register(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	coordinator := NewCoordinator(testConfig())
+	scheduler, err := NewScheduler(testConfig(), registry, coordinator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scheduler, registry, coordinator
+}
+
+// report tells the coordinator how many payloads a node has queued.
+func report(coordinator *Coordinator, node string, depth int) {
+	var reply bool
+	status := api.WriterStatus{QueueDepth: map[string]int{"announcement": depth}}
+	coordinator.Report(api.ReportArgs{Node: node, Status: status}, &reply)
+}
+
+func TestSchedulerPick(t *testing.T) {
+	scheduler, registry, coordinator := newTestScheduler(t, "localhost:1", "localhost:2")
+	report(coordinator, "localhost:1", 5)
+	report(coordinator, "localhost:2", 0)
+
+	var send api.ScheduledSend
+	if err := scheduler.Schedule(api.ScheduleArgs{Work: "SAY #a hello", Delay: time.Hour}, &send); err != nil {
+		t.Fatal(err)
+	}
+
+	if node, err := scheduler.pick(send.ID); err != nil || node != "localhost:2" {
+		t.Error(node, err, "The least busy writer should have been picked.")
+	}
+
+	// Retries stay with the writer that knows the key, however busy.
+	report(coordinator, "localhost:2", 10)
+	if node, err := scheduler.pick(send.ID); err != nil || node != "localhost:2" {
+		t.Error(node, err, "The retry should have gone to the same writer.")
+	}
+
+	// Once that writer is gone, another has to do.
+	registry.Deregister("localhost:2")
+	if node, err := scheduler.pick(send.ID); err != nil || node != "localhost:1" {
+		t.Error(node, err, "A registered writer should have been picked.")
+	}
+
+	registry.Deregister("localhost:1")
+	if _, err := scheduler.pick(send.ID); err == nil {
+		t.Error("Without writers, nothing can be picked.")
+	}
+}
+
+func TestSchedulerDispatch(t *testing.T) {
+	worker := &recordingWorker{}
+	node := serveWriter(t, worker)
+
+	scheduler, _, _ := newTestScheduler(t, node)
+
+	var send api.ScheduledSend
+	if err := scheduler.Schedule(api.ScheduleArgs{Work: "SAY #a hello"}, &send); err != nil {
+		t.Fatal(err)
+	}
+	go scheduler.Dispatch(context.Background())
+
+	for i := 0; i < 100 && len(worker.Work()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	work := worker.Work()
+	if len(work) != 1 || work[0] != "@key=schedule-"+send.ID+" SAY #a hello" {
+		t.Fatal(work, "The send did not reach the writer with its key.")
+	}
+
+	var list []api.ScheduledSend
+	for i := 0; i < 100; i++ {
+		scheduler.List(true, &list)
+		if len(list) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(list) != 0 {
+		t.Error(list, "A send that went out should leave the schedule.")
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	// Nothing listens on the node, so the send can't reach it.
+	scheduler, _, _ := newTestScheduler(t, "localhost:1")
+
+	var send api.ScheduledSend
+	if err := scheduler.Schedule(api.ScheduleArgs{Work: "SAY #a hello", Delay: time.Hour}, &send); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.send(send)
+
+	var list []api.ScheduledSend
+	scheduler.List(true, &list)
+	if len(list) != 1 || list[0].Node != "localhost:1" {
+		t.Error(list, "A send that failed should stay scheduled for its writer.")
+	}
+
+	// Work that is invalid fails the same way every time, so it is dropped.
+	if err := scheduler.Schedule(api.ScheduleArgs{Work: "SHOUT #a hello"}, &send); err == nil {
+		t.Error("Invalid work should not be scheduled.")
+	}
+}
+
+func TestSchedulerRejected(t *testing.T) {
+	tests := []struct {
+		err  error
+		kept bool
+	}{
+		{&balancer.InvalidWorkError{Str: "The channel is in emote only mode."}, false},
+		{&common.SendError{Reason: common.ReasonRateLimit, Channel: "#a"}, true},
+		{errors.New("The connection to Twitch was lost."), true},
+	}
+
+	for _, test := range tests {
+		node := serveWriter(t, &failingWorker{test.err})
+		scheduler, _, _ := newTestScheduler(t, node)
+
+		var send api.ScheduledSend
+		if err := scheduler.Schedule(api.ScheduleArgs{Work: "SAY #a hello"}, &send); err != nil {
+			t.Fatal(err)
+		}
+
+		scheduler.send(send)
+		scheduler.send(send)
+
+		var list []api.ScheduledSend
+		scheduler.List(true, &list)
+		if !test.kept {
+			if len(list) != 0 {
+				t.Error(test.err, "Invalid work should leave the schedule.")
+			}
+			continue
+		}
+
+		if len(list) != 1 {
+			t.Error(test.err, "A send that may pass should stay scheduled.")
+			continue
+		}
+
+		// Each failure holds it back for longer.
+		pending := scheduler.pending[send.ID]
+		if pending.failures != 2 || time.Until(pending.retryAt) <= scheduleRetry {
+			t.Error(pending.failures, pending.retryAt, "The retry should have backed off.")
+		}
+	}
+}
+
+func TestSchedulerSave(t *testing.T) {
+	config := testConfig()
+	config.Master.SchedulePath = filepath.Join(t.TempDir(), "schedule.json")
+
+	scheduler, err := NewScheduler(config, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var send api.ScheduledSend
+	if err := scheduler.Schedule(api.ScheduleArgs{Work: "SAY #a hello", Delay: time.Hour}, &send); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted master picks up where the last one left off.
+	restarted, err := NewScheduler(config, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list []api.ScheduledSend
+	restarted.List(true, &list)
+	if len(list) != 1 || list[0].ID != send.ID || !list[0].Due.Equal(send.Due) {
+		t.Error(list, "The schedule was not loaded from disk.")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v1"
+
+	"github.com/magnesium38/balancer"
+	"github.com/magnesium38/lbdemo/common"
+)
+
+// Twitch lets a regular user send one message per second in a channel even
+//   when slow mode is off.
+const minimumSendGap = time.Second
+
+// How long sends to a channel that was just joined wait for its state.
+const joinWait = 2 * time.Second
+
+// A channelState is what the writer knows about itself and the room in one
+//   channel. It is learned from the USERSTATE and ROOMSTATE lines Twitch
+//   sends on the writer's own connection once it joins the channel.
+type channelState struct {
+	// Known is set once a USERSTATE has been seen, before then the writer
+	//   does not know whether it is a moderator.
+	Known         bool      `json:"known"`
+	Joined        time.Time `json:"joined"`
+	Moderator     bool      `json:"moderator"`
+	Subscriber    bool      `json:"subscriber"`
+	SlowMode      int       `json:"slowMode"`
+	FollowersOnly int       `json:"followersOnly"`
+	EmoteOnly     bool      `json:"emoteOnly"`
+	SubsOnly      bool      `json:"subsOnly"`
+	UniqueChat    bool      `json:"uniqueChat"`
+	LastSent      time.Time `json:"lastSent"`
+	// NotFollowing is set when Twitch turns a message down for followers-
+	//   only mode, since it never says whether the writer follows.
+	NotFollowing bool `json:"notFollowing"`
+}
+
+// channelStates tracks a channelState for every channel the writer hears
+//   about.
+type channelStates struct {
+	sync.Mutex
+	channels map[string]*channelState
+}
+
+func newChannelStates() *channelStates {
+	return &channelStates{
+		channels: make(map[string]*channelState),
+	}
+}
+
+// Update reads a line from IRC and records any state it carries.
+func (states *channelStates) Update(line string) {
+	tags, rest := common.ParseTags(line)
+
+	message := irc.ParseMessage(rest)
+	if message == nil || len(message.Params) == 0 {
+		return
+	}
+
+	switch message.Command {
+	case "USERSTATE":
+		states.Lock()
+		defer states.Unlock()
+
+		state := states.get(message.Params[0])
+		state.Known = true
+		state.Moderator = tags["mod"] == "1" ||
+			strings.Contains(tags["badges"], "broadcaster/")
+		state.Subscriber = tags["subscriber"] == "1"
+	case "ROOMSTATE":
+		states.Lock()
+		defer states.Unlock()
+
+		// A ROOMSTATE only carries the modes that changed, so only touch
+		//   the ones that are present.
+		state := states.get(message.Params[0])
+		if value, ok := tags["slow"]; ok {
+			state.SlowMode, _ = strconv.Atoi(value)
+		}
+		if value, ok := tags["followers-only"]; ok {
+			state.FollowersOnly, _ = strconv.Atoi(value)
+			if state.FollowersOnly < 0 {
+				state.NotFollowing = false
+			}
+		}
+		if value, ok := tags["emote-only"]; ok {
+			state.EmoteOnly = value == "1"
+		}
+		if value, ok := tags["subs-only"]; ok {
+			state.SubsOnly = value == "1"
+		}
+		if value, ok := tags["r9k"]; ok {
+			state.UniqueChat = value == "1"
+		}
+	case irc.NOTICE:
+		if !strings.HasPrefix(tags["msg-id"], common.ReasonFollowersOnly) {
+			return
+		}
+
+		states.Lock()
+		defer states.Unlock()
+
+		states.get(message.Params[0]).NotFollowing = true
+	}
+}
+
+// Joining records that the writer just joined a channel, so sends to it
+//   wait a moment for its state to arrive.
+func (states *channelStates) Joining(channel string) {
+	states.Lock()
+	defer states.Unlock()
+
+	states.get(channel).Joined = time.Now()
+}
+
+// Wait returns how long a payload has to wait before it can be sent
+//   without tripping slow mode. Moderators are not paced. A channel that
+//   was just joined waits until its state is known, or joinWait is up.
+func (states *channelStates) Wait(payload writePayload) time.Duration {
+	if payload.channel == "" || payload.priority == priorityProtocol {
+		return 0
+	}
+
+	states.Lock()
+	defer states.Unlock()
+
+	state, ok := states.channels[payload.channel]
+	if !ok {
+		return 0
+	}
+
+	if !state.Known {
+		return time.Until(state.Joined.Add(joinWait))
+	}
+
+	if state.Moderator {
+		return 0
+	}
+
+	gap := time.Duration(state.SlowMode) * time.Second
+	if gap < minimumSendGap {
+		gap = minimumSendGap
+	}
+
+	return time.Until(state.LastSent.Add(gap))
+}
+
+// Check returns an error for payloads Twitch is known to reject in the
+//   channel's current state. Twitch doesn't say which words are emotes, so
+//   in emote-only mode all chat from regular users is turned down. Unique
+//   chat is left to the duplicate policy, which sees every message first.
+func (states *channelStates) Check(payload writePayload) error {
+	if payload.channel == "" || payload.priority == priorityProtocol {
+		return nil
+	}
+
+	states.Lock()
+	defer states.Unlock()
+
+	state, ok := states.channels[payload.channel]
+	if !ok || !state.Known || state.Moderator {
+		return nil
+	}
+
+	if payload.priority == priorityModeration {
+		return &balancer.InvalidWorkError{
+			Str: "Not a moderator in the channel: " + payload.channel,
+		}
+	}
+
+	if state.SubsOnly && !state.Subscriber {
+		return &balancer.InvalidWorkError{
+			Str: "The channel is in subscribers-only mode: " + payload.channel,
+		}
+	}
+
+	if state.EmoteOnly {
+		return &balancer.InvalidWorkError{
+			Str: "The channel is in emote-only mode: " + payload.channel,
+		}
+	}
+
+	if state.FollowersOnly >= 0 && state.NotFollowing {
+		return &balancer.InvalidWorkError{
+			Str: "The channel is in followers-only mode: " + payload.channel,
+		}
+	}
+
+	return nil
+}
+
+// IsModerator reports whether the writer is a moderator in a channel.
+func (states *channelStates) IsModerator(channel string) bool {
+	states.Lock()
+	defer states.Unlock()
+
+	state, ok := states.channels[channel]
+	return ok && state.Moderator
+}
+
+// Sent records that a message was just written to a channel.
+func (states *channelStates) Sent(channel string) {
+	if channel == "" {
+		return
+	}
+
+	states.Lock()
+	defer states.Unlock()
+
+	states.get(channel).LastSent = time.Now()
+}
+
+// List returns a copy of the state of every known channel.
+func (states *channelStates) List() map[string]channelState {
+	states.Lock()
+	defer states.Unlock()
+
+	list := make(map[string]channelState)
+	for channel, state := range states.channels {
+		list[channel] = *state
+	}
+
+	return list
+}
+
+// get returns the state for a channel, creating it if needed. The lock must
+//   be held by the caller.
+func (states *channelStates) get(channel string) *channelState {
+	state, ok := states.channels[channel]
+	if !ok {
+		// Followers-only mode is off until a ROOMSTATE says otherwise.
+		state = &channelState{FollowersOnly: -1}
+		states.channels[channel] = state
+	}
+
+	return state
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelStatesCheck(t *testing.T) {
+	chat := newPayload("PRIVMSG #a :hello", priorityAnnouncement, "#a")
+	moderation := newPayload("PRIVMSG #a :/ban someone", priorityModeration, "#a")
+
+	tests := []struct {
+		name  string
+		lines []string
+		// The payloads that should be turned down, and those that should not.
+		rejected []writePayload
+		allowed  []writePayload
+	}{
+		{
+			name:    "unknown",
+			allowed: []writePayload{chat, moderation},
+		},
+		{
+			name:     "regular",
+			lines:    []string{"@mod=0;subscriber=0 :tmi.twitch.tv USERSTATE #a"},
+			rejected: []writePayload{moderation},
+			allowed:  []writePayload{chat},
+		},
+		{
+			name: "moderator",
+			lines: []string{
+				"@mod=1 :tmi.twitch.tv USERSTATE #a",
+				"@emote-only=1;subs-only=1 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			allowed: []writePayload{chat, moderation},
+		},
+		{
+			name: "subs-only",
+			lines: []string{
+				"@mod=0;subscriber=0 :tmi.twitch.tv USERSTATE #a",
+				"@subs-only=1 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			rejected: []writePayload{chat},
+		},
+		{
+			name: "subs-only as a subscriber",
+			lines: []string{
+				"@mod=0;subscriber=1 :tmi.twitch.tv USERSTATE #a",
+				"@subs-only=1 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			allowed: []writePayload{chat},
+		},
+		{
+			name: "emote-only",
+			lines: []string{
+				"@mod=0 :tmi.twitch.tv USERSTATE #a",
+				"@emote-only=1 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			rejected: []writePayload{chat},
+		},
+		{
+			name: "followers-only before a rejection",
+			lines: []string{
+				"@mod=0 :tmi.twitch.tv USERSTATE #a",
+				"@followers-only=10 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			allowed: []writePayload{chat},
+		},
+		{
+			name: "followers-only after a rejection",
+			lines: []string{
+				"@mod=0 :tmi.twitch.tv USERSTATE #a",
+				"@followers-only=10 :tmi.twitch.tv ROOMSTATE #a",
+				"@msg-id=msg_followersonly :tmi.twitch.tv NOTICE #a :This room is in 10 minutes followers-only mode.",
+			},
+			rejected: []writePayload{chat},
+		},
+		{
+			name: "followers-only turned off",
+			lines: []string{
+				"@mod=0 :tmi.twitch.tv USERSTATE #a",
+				"@followers-only=10 :tmi.twitch.tv ROOMSTATE #a",
+				"@msg-id=msg_followersonly_zero :tmi.twitch.tv NOTICE #a :This room is in followers-only mode.",
+				"@followers-only=-1 :tmi.twitch.tv ROOMSTATE #a",
+			},
+			allowed: []writePayload{chat},
+		},
+	}
+
+	for _, test := range tests {
+		states := newChannelStates()
+		for _, line := range test.lines {
+			states.Update(line)
+		}
+
+		for _, payload := range test.rejected {
+			if err := states.Check(payload); err == nil {
+				t.Error(test.name, payload.msg, "The payload should have been turned down.")
+			}
+		}
+
+		for _, payload := range test.allowed {
+			if err := states.Check(payload); err != nil {
+				t.Error(test.name, payload.msg, err, "The payload should have been allowed.")
+			}
+		}
+	}
+}
+
+func TestChannelStatesWait(t *testing.T) {
+	states := newChannelStates()
+	chat := newPayload("PRIVMSG #a :hello", priorityAnnouncement, "#a")
+
+	if wait := states.Wait(chat); wait > 0 {
+		t.Error(wait, "A channel that was never joined has nothing to wait on.")
+	}
+
+	// Right after joining, sends wait for the state to arrive.
+	states.Joining("#a")
+	if wait := states.Wait(chat); wait <= 0 || wait > joinWait {
+		t.Error(wait, "Sends should wait for the state of a joined channel.")
+	}
+
+	states.Update("@mod=0 :tmi.twitch.tv USERSTATE #a")
+	states.Update("@slow=30 :tmi.twitch.tv ROOMSTATE #a")
+	if wait := states.Wait(chat); wait > 0 {
+		t.Error(wait, "Nothing was sent yet, so there is nothing to wait on.")
+	}
+
+	states.Sent("#a")
+	if wait := states.Wait(chat); wait < 29*time.Second {
+		t.Error(wait, "Slow mode should pace the sends.")
+	}
+
+	states.Update("@mod=1 :tmi.twitch.tv USERSTATE #a")
+	if wait := states.Wait(chat); wait > 0 {
+		t.Error(wait, "Moderators should not be paced.")
+	}
+}
+
+func TestIsChannelChat(t *testing.T) {
+	tests := map[string]bool{
+		"@badges= :user!user@user.tmi.twitch.tv PRIVMSG #a :hello": true,
+		":tmi.twitch.tv USERNOTICE #a :resub":                      true,
+		":tmi.twitch.tv USERSTATE #a":                              false,
+		"@msg-id=msg_slowmode :tmi.twitch.tv NOTICE #a :slow":      false,
+		":user!user@user.tmi.twitch.tv WHISPER bot :hi":            false,
+		"PING :tmi.twitch.tv":                                      false,
+	}
+
+	for line, expected := range tests {
+		if isChannelChat(line) != expected {
+			t.Error(line, "The line was not classified as expected.")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sendStats keeps track of what a writer sent over the last window, and
+//   how long those messages waited in the queue first.
+type sendStats struct {
+	sync.Mutex
+	window time.Duration
+	sends  []sendRecord
+}
+
+type sendRecord struct {
+	at   time.Time
+	wait time.Duration
+}
+
+func newSendStats(window time.Duration) *sendStats {
+	return &sendStats{
+		window: window,
+	}
+}
+
+// Record notes that a message was sent after waiting in the queue.
+func (stats *sendStats) Record(wait time.Duration) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	stats.prune()
+	stats.sends = append(stats.sends, sendRecord{time.Now(), wait})
+}
+
+// Snapshot returns how many messages were sent in the window, and how long
+//   they waited on average.
+func (stats *sendStats) Snapshot() (int, time.Duration) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	stats.prune()
+	if len(stats.sends) == 0 {
+		return 0, 0
+	}
+
+	var total time.Duration
+	for _, send := range stats.sends {
+		total += send.wait
+	}
+
+	return len(stats.sends), total / time.Duration(len(stats.sends))
+}
+
+// prune forgets sends older than the window. The lock must be held by the
+//   caller.
+func (stats *sendStats) prune() {
+	cutoff := time.Now().Add(-stats.window)
+
+	i := 0
+	for i < len(stats.sends) && stats.sends[i].at.Before(cutoff) {
+		i++
+	}
+
+	stats.sends = stats.sends[i:]
+}
@@ -1,78 +1,219 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net"
-	"net/rpc"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"gopkg.in/sorcix/irc.v1"
+
 	"github.com/magnesium38/balancer"
 	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
 )
 
-// NewWriter creates a new writer worker.
-func NewWriter(config *common.Config) (*Writer, error) {
-	appServer, err := rpc.DialHTTP("tcp", config.Address.App.String())
-	if err != nil {
+// NewWriter creates a new writer worker. The name identifies the node to
+//   the writer master when asking for send tokens, and the jobs are what it
+//   reports on in its status.
+func NewWriter(config *common.Config, name string, jobs *common.WorkGroup) (*Writer, error) {
+	// Both masters are dialed again whenever they restart.
+	appServer := api.NewMasterClient(config.Address.App.String(), config.Client)
+	if err := appServer.Connect(); err != nil {
+		return nil, err
+	}
+
+	// Establish a connection to the writer master's coordinator.
+	coordinator := api.NewCoordinatorClient(config.Master.Control.String(), config.Client)
+	if err := coordinator.Connect(); err != nil {
+		appServer.Close()
 		return nil, err
 	}
 
 	worker := Writer{
 		config,
-		true,
+		name,
+		atomic.Pointer[string]{},
+		atomic.Bool{},
 		nil,
 		appServer,
-		make(chan writePayload),
+		coordinator,
+		newSendQueue(),
+		newChannelStates(),
+		newPendingSends(time.Duration(config.Irc.NoticeWindow) * time.Millisecond),
+		newRecentMessages(),
+		nil,
+		newSendResults(idempotencyExpiry(config)),
+		nil,
+		newSendStats(time.Duration(config.Irc.MessageWindow) * time.Second),
+		jobs,
+	}
+
+	// The spool is optional. When it is used, anything left in it from
+	//   before a restart is replayed once the connection is up.
+	if config.Irc.SpoolPath != "" {
+		err := worker.openSpool()
+		if err != nil {
+			appServer.Close()
+			coordinator.Close()
+			return nil, err
+		}
 	}
 
 	return &worker, nil
 }
 
+// openSpool opens this node's spool and sets aside what needs replaying.
+//   The file is named after the node ID, so a restarted node finds its
+//   spool again even on another port. Without an ID, the node needs a
+//   fixed port for that. Finished work is kept as long as its key is
+//   remembered.
+func (worker *Writer) openSpool() error {
+	id := worker.config.NodeID
+	if id == "" {
+		id = strings.Replace(worker.name, ":", "_", -1)
+	}
+	path := filepath.Join(worker.config.Irc.SpoolPath, id+".spool")
+	keep := idempotencyExpiry(worker.config)
+
+	spool, entries, err := common.OpenSpool(path, keep)
+	if err != nil {
+		return err
+	}
+	worker.spool = spool
+
+	// Finished work is only needed so retries of its key are answered.
+	//   Unfinished work claims its key now, so a retry waits on the replay.
+	replay := spoolReplay{
+		results: make(map[string]*sendResult),
+	}
+	for _, entry := range entries {
+		if entry.Done {
+			worker.results.Restore(entry.Key, entry.Response, entry.Error, entry.Finished)
+			continue
+		}
+
+		if entry.Key != "" {
+			result, existing := worker.results.Begin(entry.Key)
+			if !existing {
+				replay.results[entry.ID] = result
+			}
+		}
+		replay.entries = append(replay.entries, entry)
+	}
+	worker.replay = &replay
+
+	return nil
+}
+
+// spoolReplay is the work a writer found in its spool at startup.
+type spoolReplay struct {
+	entries []common.SpoolEntry
+	results map[string]*sendResult
+}
+
 type writePayload struct {
 	msg      string
 	doneChan chan error
+	priority priority
+	channel  string
+	// tokens is how many sends to take from the rate limit before writing.
+	//   A message split into pieces takes them all with its first piece.
+	tokens   int
+	enqueued time.Time
 }
 
 // A Writer is how the node writes to irc.
 type Writer struct {
-	config    *common.Config
-	doWork    bool
-	ircConn   *net.Conn
-	appServer *rpc.Client
-	toWrite   chan writePayload
+	config      *common.Config
+	name        string
+	account     atomic.Pointer[string]
+	halted      atomic.Bool
+	ircConn     common.IRCConn
+	appServer   *api.MasterClient
+	coordinator *api.CoordinatorClient
+	queue       *sendQueue
+	states      *channelStates
+	pending     *pendingSends
+	recent      *recentMessages
+	spool       *common.Spool
+	results     *sendResults
+	replay      *spoolReplay
+	stats       *sendStats
+	jobs        *common.WorkGroup
 }
 
 // Work is the main function to write to the irc connection.
 func (worker *Writer) Work() error {
+	// Log in as the account leased from the writer master, which is the
+	//   config's own when there is no pool of them. A restarted node gets
+	//   the same account back.
+	account, err := worker.coordinator.Lease(worker.name)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := worker.config.Irc.Credentials(account)
+	if err != nil {
+		return err
+	}
+	worker.account.Store(&account)
+
 	// The work is sending whatever payloads are received. But first
 	//   the initial connection must be processed.
-	ircConn, err := net.Dial("tcp", worker.config.Irc.ConnInfo)
+	ircConn, err := common.DialIRC(worker.config.Irc.ConnInfo, worker.config.Irc.Proxy, nil,
+		worker.config.Irc.DialTimeout())
 	if err != nil {
 		return err
 	}
 	defer ircConn.Close()
 
-	// These two lines needs to be writen first. Setup a goroutine to send
-	//   them first.
-	go func() {
-		doneChan := make(chan error)
-		worker.toWrite <- writePayload{"PASS " + worker.config.Irc.Password, doneChan}
-		<-doneChan
-		worker.toWrite <- writePayload{"NICK " + worker.config.Irc.Nickname, doneChan}
-		<-doneChan
-	}()
+	// These lines needs to be writen first. They are protocol messages
+	//   so they go ahead of anything else already queued, and the queue
+	//   keeps them in order. The capabilities are what get Twitch to send
+	//   the USERSTATE and ROOMSTATE lines used to track channel state.
+	worker.enqueue("CAP REQ :twitch.tv/tags twitch.tv/commands", false)
+	for _, line := range credentials.Login() {
+		worker.enqueue(line, false)
+	}
+
+	// Send whatever was left over from before a restart.
+	if worker.replay != nil {
+		worker.replaySpool(worker.replay)
+		worker.replay = nil
+	}
 
 	// Start the reader and get the writer.
-	go worker.startReader(ircConn)
-	writer := bufio.NewWriter(ircConn)
+	lost := make(chan struct{})
+	go worker.startReader(ircConn, lost)
 
 	fmt.Println("Starting `work`.")
 
-	for worker.doWork {
-		payload := <-worker.toWrite
+	// The channels joined on this connection.
+	joined := make(map[string]bool)
+
+	for !worker.halted.Load() {
+		payload := worker.queue.Pop(worker.states.Wait)
+
+		// If the connection died, keep the payload for the next connection
+		//   and let the work be restarted. It goes back where it was, so
+		//   the pieces of a split message stay in order.
+		select {
+		case <-lost:
+			if payload.msg != "" {
+				worker.queue.Return(payload)
+			} else {
+				payload.doneChan <- nil
+			}
+			return errors.New("The connection to IRC was lost.")
+		default:
+		}
 
 		// If the payload is empty, no need to attempt to write it. No error.
 		if payload.msg == "" {
@@ -80,37 +221,173 @@ func (worker *Writer) Work() error {
 			continue
 		}
 
+		// A line break would let the payload inject more commands.
+		if strings.ContainsAny(payload.msg, "\r\n") {
+			payload.doneChan <- common.ErrLineBreak
+			continue
+		}
+
+		// Twitch only sends a channel's state to those in it, so join it
+		//   before the first send. The payload goes back to wait for the
+		//   state to arrive.
+		if needsJoin(payload) && !joined[payload.channel] {
+			joined[payload.channel] = true
+			worker.states.Joining(payload.channel)
+			ircConn.WriteLine("JOIN " + payload.channel)
+			worker.queue.Return(payload)
+			continue
+		}
+
+		// Don't send what the channel's state says will be rejected.
+		if err := worker.states.Check(payload); err != nil {
+			payload.doneChan <- err
+			continue
+		}
+
+		// Chat messages count against the account's rate limit, which is
+		//   shared with every other writer node.
+		if payload.tokens > 0 {
+			moderator := worker.states.IsModerator(payload.channel)
+			if err := worker.acquire(payload.tokens, moderator); err != nil {
+				payload.doneChan <- err
+				continue
+			}
+		}
+
 		// Write the payload plus the new line. Making an assumption that all
 		//   bytes will always be written and so the first argument can be
 		//   ignored. The error is being deferred to whatever gave the payload.
 		//   TO DO: Should I log the error here as well to make it easier
 		//   to track down if it is serious?
-		_, err := writer.WriteString(payload.msg + "\r\n")
-		writer.Flush()
+		err := ircConn.WriteLine(payload.msg)
+		worker.states.Sent(payload.channel)
+
+		if payload.priority != priorityProtocol {
+			worker.stats.Record(time.Since(payload.enqueued))
+		}
+
+		// A chat message that was written can still be rejected by Twitch,
+		//   so its result waits until that is known.
+		if err == nil && payload.priority != priorityProtocol {
+			worker.pending.Add(payload.channel, payload.doneChan)
+			continue
+		}
 
 		payload.doneChan <- err
 	}
 
-	return errors.New("The worker was instructed to stop.")
+	return common.ErrHalted
+}
+
+// needsJoin returns whether a payload goes to a channel the writer has to
+//   be in. Whispers go through #jtv, which is never joined.
+func needsJoin(payload writePayload) bool {
+	return payload.priority != priorityProtocol &&
+		payload.channel != "" &&
+		payload.channel != "#jtv"
+}
+
+// acquire blocks until the coordinator grants count send tokens. Sends to
+//   a channel where the writer is a moderator have a higher limit. More
+//   tokens than the node's share can never be granted at once, so they are
+//   then taken a share at a time.
+func (worker *Writer) acquire(count int, moderator bool) error {
+	chunk := count
+
+	for count > 0 {
+		args := api.AcquireArgs{
+			Node:      worker.name,
+			Account:   worker.leased(),
+			Count:     chunk,
+			Moderator: moderator,
+		}
+		reply, err := worker.coordinator.Acquire(args)
+		if err != nil {
+			return err
+		}
+
+		if reply.Wait == 0 {
+			count -= chunk
+			if chunk > count {
+				chunk = count
+			}
+			continue
+		}
+
+		share := reply.Share
+		if share < 1 {
+			share = 1
+		}
+		if chunk > share {
+			chunk = share
+			continue
+		}
+
+		time.Sleep(reply.Wait)
+	}
+
+	return nil
 }
 
-func (worker *Writer) startReader(conn net.Conn) {
-	// Create the reader and the sleep duration.
-	reader := bufio.NewReader(conn)
-	sleepDuration := time.Duration(worker.config.Irc.ReadFrequency) * time.Millisecond
+// enqueue classifies a line and queues it to be written. The returned
+//   channel receives the result of the write. Replies to chat are marked
+//   by isReply so they go ahead of announcements.
+func (worker *Writer) enqueue(msg string, isReply bool) chan error {
+	priority, channel := classify(msg)
+	if isReply && priority == priorityAnnouncement {
+		priority = priorityReply
+	}
 
+	return worker.push(msg, priority, channel)
+}
+
+// push queues a line to be written with the given priority and channel.
+func (worker *Writer) push(msg string, priority priority, channel string) chan error {
+	payload := newPayload(msg, priority, channel)
+	worker.queue.Push(payload)
+
+	return payload.doneChan
+}
+
+// newPayload creates a payload for a line. Anything other than protocol
+//   messages is chat, which takes a send from the rate limit.
+func newPayload(msg string, priority priority, channel string) writePayload {
+	tokens := 0
+	if priority != priorityProtocol {
+		tokens = 1
+	}
+
+	// The channel is buffered so writing never waits on whoever queued it.
+	return writePayload{msg, make(chan error, 1), priority, channel, tokens, time.Now()}
+}
+
+// startReader reads from the connection until it dies, and then closes
+//   lost so the work loop can stop and be restarted.
+func (worker *Writer) startReader(conn common.IRCConn, lost chan struct{}) {
 	for {
-		line, err := reader.ReadString('\n')
+		// ReadLine blocks until there is a line, so an error means the
+		//   connection has died.
+		line, err := conn.ReadLine()
 		if err != nil {
-			if err == io.EOF {
-				// The connection has died. Attempt to recover or die.
-				// TO DO:
+			if err != io.EOF {
+				fmt.Println("Read error:", err)
 			}
 
-			// Most likely just not enough to read. Sleep it off.
-			// ^^ Not true. reader.ReadString is blocking unless EOF.
-			// This should be handled somehow. TO DO:
-			time.Sleep(sleepDuration)
+			close(lost)
+
+			// Wake up the work loop so it sees the connection is gone.
+			worker.enqueue("", false)
+			return
+		}
+
+		// Keep track of the writer's standing in its channels, and whether
+		//   what it sent was accepted.
+		worker.states.Update(line)
+		worker.pending.Update(line)
+
+		// The readers already pass on the chat of every channel, including
+		//   the ones the writer joined to send to.
+		if isChannelChat(line) {
 			continue
 		}
 
@@ -119,19 +396,34 @@ func (worker *Writer) startReader(conn net.Conn) {
 	}
 }
 
+// isChannelChat returns whether a line is chat in a channel.
+func isChannelChat(line string) bool {
+	_, rest := common.ParseTags(line)
+
+	message := irc.ParseMessage(rest)
+	if message == nil || len(message.Params) == 0 {
+		return false
+	}
+
+	switch message.Command {
+	case irc.PRIVMSG, "USERNOTICE", "CLEARCHAT", "CLEARMSG":
+		return strings.HasPrefix(message.Params[0], "#")
+	}
+
+	return false
+}
+
 func (worker *Writer) process(line string) {
 	// Pass the line onto the app server's load balancer.
-	var reply string
-	err := worker.appServer.Call("Master.Work", line, &reply)
+	reply, err := worker.appServer.Work(line)
 	if err != nil {
 		// If there's an error, it's something the app server returned.
 		//   Should be safe to just log and ignore.
 		// TO DO: Log this.
 	}
 
-	// Create the payload.
-	doneChan := make(chan error)
-	worker.toWrite <- writePayload{reply, doneChan}
+	// Queue the reply.
+	doneChan := worker.enqueue(reply, true)
 
 	// Process the error??? Honestly, I don't think I'll care most of the time.
 	err = <-doneChan
@@ -145,19 +437,371 @@ func (worker *Writer) Do(work string) (string, error) {
 	// A writer's work is to take commands as given by the app servers and
 	//   write them to the IRC connection.
 
-	// Create the payload.
-	done := make(chan error)
-	worker.toWrite <- writePayload{work, done}
+	// Work is a single line, anything more could inject extra commands.
+	if strings.ContainsAny(work, "\r\n") {
+		return "", &balancer.InvalidWorkError{
+			Str: "Work given contained a line break.",
+		}
+	}
+
+	// Work can have tags in front. A key makes retrying the same work safe,
+	//   a ttl is how many seconds the work is still worth sending, and an
+	//   account is which writer account to send it as.
+	tagged := work
+	tags, work := common.ParseTags(work)
+
+	// STATE is not written anywhere, it reports what this writer knows
+	//   about its channels. It can be narrowed down to some channels.
+	parts := strings.Fields(work)
+	if len(parts) > 0 && parts[0] == "STATE" {
+		return worker.state(parts[1:])
+	}
+
+	// If the command was halt, stop as gracefully as possible.
+	if work == "HALT" {
+		return worker.halt()
+	}
+
+	// Anything else has to be a command the writer knows how to write.
+	pieces, err := prepare(work)
+	if err != nil {
+		return "", err
+	}
+
+	// Work to be sent as another account goes to the node that leased it,
+	//   tags and all.
+	account, err := worker.sendAs(tags["account"], pieces)
+	if err != nil {
+		return "", err
+	}
+	if account != worker.leased() {
+		return worker.forward(account, tagged)
+	}
+
+	expires, err := worker.expiry(tags["ttl"])
+	if err != nil {
+		return "", err
+	}
+
+	// Without a key, there is nothing to check for retries.
+	key := tags["key"]
+	if key == "" {
+		return worker.spoolAndSend(work, key, expires, pieces)
+	}
+
+	// If the key was seen before, this is a retry. Give back the result of
+	//   the first attempt rather than sending it again.
+	result, existing := worker.results.Begin(key)
+	if existing {
+		return result.Wait()
+	}
+
+	response, err := worker.spoolAndSend(work, key, expires, pieces)
+	worker.results.Finish(result, response, err)
+
+	return response, err
+}
+
+// prepare validates work and breaks it into the commands to send.
+func prepare(work string) ([]*command, error) {
+	cmd, err := parseCommand(work)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd.pieces()
+}
+
+// sendAs returns the account work should be sent as: the one its account
+//   tag names, or else the one for its channel. Work with neither goes out
+//   as whichever account this writer has.
+func (worker *Writer) sendAs(tag string, pieces []*command) (string, error) {
+	if tag != "" {
+		if !worker.config.Irc.IsWriterAccount(tag) {
+			return "", &balancer.InvalidWorkError{
+				Str: "Work given named an account that is not a writer account: " + tag,
+			}
+		}
+
+		return tag, nil
+	}
+
+	if account, ok := worker.config.Irc.ChannelAccounts[pieces[0].channel]; ok {
+		return account, nil
+	}
+
+	return worker.leased(), nil
+}
+
+// leased returns the account the writer logged in as, which is empty for
+//   the IRC config's own.
+func (worker *Writer) leased() string {
+	if account := worker.account.Load(); account != nil {
+		return *account
+	}
+
+	return ""
+}
+
+// forward hands work to the writer node that leased the account. Like the
+//   load balancer, it waits for as long as the work takes.
+func (worker *Writer) forward(account string, work string) (string, error) {
+	node, err := worker.coordinator.Holder(account)
+	if err != nil {
+		return "", err
+	}
+
+	client := api.NewNodeClient(node, worker.config.Client)
+	defer client.Close()
+
+	return client.DoTimeout(work, 0)
+}
+
+// expiry returns when work stops being worth sending, using the ttl tag
+//   if it has one. A zero time means it never expires.
+func (worker *Writer) expiry(ttl string) (time.Time, error) {
+	seconds := worker.config.Irc.SpoolExpiry
+	if ttl != "" {
+		var err error
+		seconds, err = strconv.Atoi(ttl)
+		if err != nil || seconds < 0 {
+			return time.Time{}, &balancer.InvalidWorkError{
+				Str: "Work given had an invalid ttl: " + ttl,
+			}
+		}
+	}
+
+	if seconds == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Now().Add(time.Duration(seconds) * time.Second), nil
+}
+
+// spoolAndSend records the work in the spool, if there is one, before
+//   sending it so it is not lost if the node goes down first.
+func (worker *Writer) spoolAndSend(work string, key string, expires time.Time, pieces []*command) (string, error) {
+	if worker.spool == nil {
+		return worker.send(pieces)
+	}
+
+	id, err := worker.spool.Add(common.SpoolEntry{
+		Key:     key,
+		Work:    work,
+		Expires: expires,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := worker.send(pieces)
+	if spoolErr := worker.spool.Finish(id, spoolKey(key, err), response, err); spoolErr != nil {
+		fmt.Println("Spool error:", spoolErr)
+	}
+
+	return response, err
+}
 
-	// Retrieve the potential error from writing.
-	err := <-done
+// send queues the commands and waits for them to be written.
+func (worker *Writer) send(pieces []*command) (string, error) {
+	pieces, response := worker.handleDuplicates(pieces)
+	if len(pieces) == 0 {
+		return response, nil
+	}
+
+	// Queue the pieces together so they stay in order. The first piece
+	//   takes the rate limit for all of them.
+	payloads := make([]writePayload, len(pieces))
+	for i, piece := range pieces {
+		payloads[i] = newPayload(piece.line(), piece.priority(), piece.queueChannel())
+	}
+
+	if len(payloads) > 1 {
+		for i := range payloads {
+			payloads[i].tokens = 0
+		}
+		payloads[0].tokens = len(payloads)
+	}
+	worker.queue.Push(payloads...)
+
+	// Retrieve the potential error from writing. If Twitch rejected the
+	//   message, this is a common.SendError saying why. Chat that wasn't
+	//   sent gives up its claim, so it can be tried again.
+	var err error
+	for i, payload := range payloads {
+		pieceErr := <-payload.doneChan
+		if pieceErr == nil {
+			continue
+		}
+
+		if pieces[i].isChat() {
+			worker.recent.Release(pieces[i].channel, pieces[i].chatText())
+		}
+		if err == nil {
+			err = pieceErr
+		}
+	}
 
 	// If an error is here, it should be logged. TO DO: Actually log.
 	//   Probably also check that this I'm not missing something here.
 	//   This seems awfully short.
 
-	// Nothing important to return except if an error occured.
-	return "", err
+	// Nothing important to return except if an error occured, or how a
+	//   duplicate was handled.
+	return response, err
+}
+
+// replaySpool sends the work left in the spool from before a restart.
+func (worker *Writer) replaySpool(replay *spoolReplay) {
+	for _, entry := range replay.entries {
+		go func(entry common.SpoolEntry) {
+			var response string
+			pieces, err := prepare(entry.Work)
+			if err == nil {
+				response, err = worker.send(pieces)
+			}
+
+			if spoolErr := worker.spool.Finish(entry.ID, spoolKey(entry.Key, err), response, err); spoolErr != nil {
+				fmt.Println("Spool error:", spoolErr)
+			}
+
+			if result, ok := replay.results[entry.ID]; ok {
+				worker.results.Finish(result, response, err)
+			}
+		}(entry)
+	}
+}
+
+// handleDuplicates applies the duplicate policy to chat messages Twitch
+//   would drop for matching a recent one. It returns the pieces left to
+//   send and a response saying what was done, if anything.
+func (worker *Writer) handleDuplicates(pieces []*command) ([]*command, string) {
+	response := ""
+	var kept []*command
+
+	for _, piece := range pieces {
+		if !piece.isChat() {
+			kept = append(kept, piece)
+			continue
+		}
+
+		if worker.recent.Claim(piece.channel, piece.chatText()) {
+			kept = append(kept, piece)
+			continue
+		}
+
+		if worker.config.Irc.DuplicatePolicy == duplicateSuppress {
+			response = responseSuppressed
+			continue
+		}
+
+		// Keep adding variations until the text is unique again. One more
+		//   than the message has room for means it is suppressed after all.
+		varied := true
+		for !worker.recent.Claim(piece.channel, piece.chatText()) {
+			if len([]rune(piece.text+duplicateVariation)) > common.MaxMessageLength {
+				varied = false
+				break
+			}
+			piece.text += duplicateVariation
+		}
+
+		if !varied {
+			response = responseSuppressed
+			continue
+		}
+
+		response = responseVaried
+		kept = append(kept, piece)
+	}
+
+	return kept, response
+}
+
+// Halt starts the shutdown of the worker. Nothing queued is written after
+//   this, so closing the queue fails it and wakes up the work loop so it
+//   sees that it should stop.
+func (worker *Writer) halt() (string, error) {
+	worker.halted.Store(true)
+	worker.queue.Close(common.ErrHalted)
+
+	return "", nil
+}
+
+// stateReply is what STATE replies with. Every writer only knows about its
+//   own connection, so the reply names the node it came from. To see a
+//   particular writer's state, hand STATE to that node through the writer
+//   master's Master.Do, with Master.Nodes listing them.
+type stateReply struct {
+	Node     string                  `json:"node"`
+	Channels map[string]channelState `json:"channels"`
+}
+
+// state encodes the state of the requested channels, or all of them.
+func (worker *Writer) state(channels []string) (string, error) {
+	states := worker.states.List()
+
+	if len(channels) > 0 {
+		requested := make(map[string]channelState)
+		for _, channel := range channels {
+			state, ok := states[channel]
+			if !ok {
+				return "", &balancer.InvalidWorkError{
+					Str: "No state known for the channel: " + channel,
+				}
+			}
+
+			requested[channel] = state
+		}
+
+		states = requested
+	}
+
+	encoded, err := json.Marshal(stateReply{worker.name, states})
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+// How often a draining writer checks whether its queue is empty.
+const drainFrequency = 100 * time.Millisecond
+
+// Drain flushes the writer's queue before the node stops. Work handed to
+//   the writer has already been written by the time this is called, but
+//   replies to chat may still be queued.
+func (worker *Writer) Drain(deadline time.Time) error {
+	for queued(worker.queue.Depths()) > 0 {
+		if time.Now().After(deadline) {
+			return errors.New("The queue was not flushed in time.")
+		}
+
+		time.Sleep(drainFrequency)
+	}
+
+	// Quit while the work loop is still around to write it.
+	select {
+	case err := <-worker.enqueue("QUIT Shutting Down", false):
+		if err != nil {
+			return err
+		}
+	case <-time.After(time.Until(deadline)):
+		return errors.New("The quit message was not sent in time.")
+	}
+
+	worker.halt()
+	return nil
+}
+
+// queued adds up the depths of every priority.
+func queued(depths map[string]int) int {
+	total := 0
+	for _, depth := range depths {
+		total += depth
+	}
+
+	return total
 }
 
 // Shutdown starts as graceful of a shutdown of the worker as possible.
@@ -165,15 +809,52 @@ func (worker *Writer) Shutdown() {
 	// Close the RPC connection to the App Server.
 	worker.appServer.Close()
 
-	// Send a quit message to terminate the connection.
-	worker.toWrite <- writePayload{"QUIT Shutting Down", make(chan error)}
+	// Give this node's share of the rate limit back to the other writers.
+	worker.coordinator.Release(worker.name)
+	worker.coordinator.Close()
+
+	if worker.spool != nil {
+		worker.spool.Close()
+	}
 
 	// Breaking the work loop is fine. This'll cause it to return an error
-	//   which in turn will cause the process to exit.
-	worker.doWork = false
+	//   which in turn will cause the process to exit. Anything still queued
+	//   fails, a DRAIN first is what flushes it.
+	worker.halt()
 }
 
+// Status reports how much is waiting to be written and how much was sent.
 func (worker *Writer) Status(requestTime time.Time) balancer.Status {
-	// TO DO: revisit statuses.
-	return common.NewStatus()
+	sent, wait := worker.stats.Snapshot()
+
+	return &api.WriterStatus{
+		QueueDepth:   worker.queue.Depths(),
+		MessagesSent: sent,
+		QueueWait:    wait,
+		Jobs:         worker.jobs.Stats(),
+	}
+}
+
+// Report sends the writer's status to the coordinator every message window
+//   so the writer master can tell whether it needs more writers. It stops
+//   once ctx is cancelled.
+func (worker *Writer) Report(ctx context.Context) error {
+	window := time.Duration(worker.config.Irc.MessageWindow) * time.Second
+
+	for !worker.halted.Load() {
+		status := worker.Status(time.Now()).(*api.WriterStatus)
+
+		err := worker.coordinator.Report(worker.name, worker.leased(), *status)
+		if err != nil {
+			fmt.Println("Report error:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(window):
+		}
+	}
+
+	return common.ErrHalted
 }
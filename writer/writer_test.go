@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/magnesium38/lbdemo/common"
+	"github.com/magnesium38/lbdemo/common/api"
+)
+
+// serveCoordinator publishes a coordinator on a control server, and returns
+//   a client for it once it is answering.
+func serveCoordinator(t *testing.T, coordinator *Coordinator) *api.CoordinatorClient {
+	port, err := common.GetOpenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connInfo := common.ConnInfo{Hostname: "localhost", Port: port}
+	control := common.NewControlServer(connInfo, nil, nil)
+	if err := api.RegisterCoordinator(control, coordinator); err != nil {
+		t.Fatal(err)
+	}
+	go control.ListenAndServe()
+
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", connInfo.String()); err == nil {
+			conn.Close()
+			return api.NewCoordinatorClient(connInfo.String(), common.ClientConfig{})
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("The control server never answered.")
+	return nil
+}
+
+// testConfig returns a config with the limits the writer tests use.
+func testConfig() *common.Config {
+	config := &common.Config{}
+	config.Irc.MessageLimit = 20
+	config.Irc.ModeratorMessageLimit = 100
+	config.Irc.MessageWindow = 1
+
+	return config
+}
+
+func TestAcquireOverShare(t *testing.T) {
+	coordinator := NewCoordinator(testConfig())
+	client := serveCoordinator(t, coordinator)
+	defer client.Close()
+
+	// Three nodes split a limit of 20, so each has a share of 6 or 7.
+	var reply api.AcquireReply
+	for _, node := range []string{"localhost:1", "localhost:2", "localhost:3"} {
+		coordinator.Acquire(api.AcquireArgs{Node: node}, &reply)
+	}
+	if reply.Share != 6 {
+		t.Fatal(reply.Share, "The share was not as expected.")
+	}
+
+	worker := &Writer{name: "localhost:3", coordinator: client}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.acquire(7, false)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("More pieces than the share were never granted.")
+	}
+}
+
+func TestOpenSpool(t *testing.T) {
+	config := testConfig()
+	config.Irc.SpoolPath = t.TempDir()
+	config.NodeID = "node-1"
+
+	worker := &Writer{name: "localhost:1", config: config, results: newSendResults(time.Minute)}
+	if err := worker.openSpool(); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := worker.spool.Add(common.SpoolEntry{Key: "a", Work: "SAY #a hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.spool.Finish(id, "a", "", nil)
+	worker.spool.Close()
+
+	// Restarted on another port, the node finds its spool by its ID, and
+	//   its finished work is still remembered.
+	restarted := &Writer{name: "localhost:2", config: config, results: newSendResults(time.Minute)}
+	if err := restarted.openSpool(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.spool.Close()
+
+	if _, existing := restarted.results.Begin("a"); !existing {
+		t.Error("The restarted node did not find its spool.")
+	}
+}